@@ -6,6 +6,7 @@ require (
 	github.com/miekg/dns v1.1.61
 	github.com/rs/zerolog v1.33.0
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.24.0
 	golang.org/x/net v0.26.0
 	gopkg.in/yaml.v3 v3.0.1
 )
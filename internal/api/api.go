@@ -1,10 +1,18 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/clients"
+	itls "github.com/AlexxIT/pnproxy/internal/tls"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
 	"github.com/rs/zerolog/log"
 )
 
@@ -12,6 +20,16 @@ func Init() {
 	var cfg struct {
 		API struct {
 			Listen string `yaml:"listen"`
+			TLS    struct {
+				Cert       string `yaml:"cert"`
+				Key        string `yaml:"key"`
+				SelfSigned bool   `yaml:"self_signed"`
+			} `yaml:"tls"`
+			Auth struct {
+				Tokens []string          `yaml:"tokens"`
+				Users  map[string]string `yaml:"users"`
+			} `yaml:"auth"`
+			Allow string `yaml:"allow"`
 		} `yaml:"api"`
 	}
 
@@ -21,23 +39,149 @@ func Init() {
 		return
 	}
 
-	http.HandleFunc("GET /api", api)
-	http.HandleFunc("GET /api/request", apiRequest)
-	http.HandleFunc("GET /api/stack", apiStack)
+	allow, err := clients.Get(cfg.API.Allow)
+	if err != nil {
+		app.ConfigError(fmt.Errorf("[api] wrong allow: %w", err))
+		return
+	}
 
-	go serve(cfg.API.Listen)
-}
+	auth := &authConfig{
+		tokens: cfg.API.Auth.Tokens,
+		users:  cfg.API.Auth.Users,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api", api)
+	mux.HandleFunc("GET /api/request", apiRequest)
+	mux.HandleFunc("GET /api/stack", apiStack)
+	mux.HandleFunc("GET /api/upstreams", apiUpstreams)
+	mux.HandleFunc("GET /api/state", apiState)
+	mux.HandleFunc("GET /api/tls", apiTLS)
+	mux.HandleFunc("GET /api/ca", apiCA)
+	mux.HandleFunc("DELETE /api/state", apiStateDelete)
+
+	// health check without auth for Docker and Kubernetes probes
+	root := http.NewServeMux()
+	root.HandleFunc("GET /api/health", apiHealth)
+	root.Handle("/", handleAuth(auth, handleRedact(mux)))
 
-func serve(address string) {
-	log.Info().Msgf("[api] listen=%s", address)
+	srv := &http.Server{
+		Addr:    cfg.API.Listen,
+		Handler: handleAllow(allow, root),
+	}
+
+	switch {
+	case cfg.API.TLS.Cert != "" && cfg.API.TLS.Key != "":
+		cert, err := tls.LoadX509KeyPair(cfg.API.TLS.Cert, cfg.API.TLS.Key)
+		if err != nil {
+			app.ConfigError(fmt.Errorf("[api] wrong certificate: %w", err))
+			return
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case cfg.API.TLS.SelfSigned:
+		cert, err := selfSignedCert(cfg.API.Listen)
+		if err != nil {
+			log.Error().Err(err).Caller().Send()
+			return
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	app.OnStart("api", func() error {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			return err
+		}
+		go serve(srv, ln)
+		return nil
+	})
+	app.OnStop("api", func(ctx context.Context) error {
+		return app.ShutdownServer(ctx, srv)
+	})
+}
 
-	srv := &http.Server{Addr: address}
-	if err := srv.ListenAndServe(); err != nil {
+func serve(srv *http.Server, ln net.Listener) {
+	var err error
+	if srv.TLSConfig != nil {
+		log.Info().Msgf("[api] listen=%s tls=true", srv.Addr)
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		log.Info().Msgf("[api] listen=%s", srv.Addr)
+		err = srv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Error().Err(err).Caller().Send()
+		app.ModuleStatus("api", err)
 	}
 }
 
 func api(w http.ResponseWriter, r *http.Request) {
+	info := map[string]any{"modules": app.Statuses()}
+	for k, v := range app.Info {
+		info[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+func apiUpstreams(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(upstream.Statuses())
+}
+
+func apiTLS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(itls.Conns())
+}
+
+// apiCA return local CA certificate for installing on client devices
+func apiCA(w http.ResponseWriter, r *http.Request) {
+	cert := itls.CACert()
+	if cert == nil {
+		http.Error(w, "mitm action is not used", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="pnproxy.ca.crt"`)
+	_, _ = w.Write(cert)
+}
+
+func apiState(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(app.Info)
+	_ = json.NewEncoder(w).Encode(app.States())
+}
+
+func apiStateDelete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !app.ResetState(query.Get("name"), query.Get("key")) {
+		http.Error(w, "unknown state name", http.StatusNotFound)
+	}
+}
+
+func apiHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !app.Healthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(app.Statuses())
+}
+
+// handleRedact hide secrets from config in all API responses
+func handleRedact(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(redactWriter{w}, r)
+	})
+}
+
+type redactWriter struct {
+	http.ResponseWriter
+}
+
+func (w redactWriter) Write(p []byte) (n int, err error) {
+	if _, err = w.ResponseWriter.Write(app.Redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/AlexxIT/pnproxy/internal/clients"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type authConfig struct {
+	tokens []string
+	users  map[string]string // username => bcrypt hash
+}
+
+func (a *authConfig) empty() bool {
+	return len(a.tokens) == 0 && len(a.users) == 0
+}
+
+func (a *authConfig) check(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, s := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s)) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		if hash, ok := a.users[username]; ok {
+			return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+		}
+	}
+
+	return false
+}
+
+func handleAuth(auth *authConfig, next http.Handler) http.Handler {
+	if auth.empty() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.check(r) {
+			log.Debug().Msgf("[api] unauthorized remote_addr=%s", r.RemoteAddr)
+			if len(auth.users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="pnproxy"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleAllow(nets []*net.IPNet, next http.Handler) http.Handler {
+	if nets == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if clients.Contains(nets, r.RemoteAddr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Debug().Msgf("[api] forbidden remote_addr=%s", r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
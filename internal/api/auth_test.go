@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AlexxIT/pnproxy/internal/clients"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pasw1"), bcrypt.MinCost)
+	require.Nil(t, err)
+
+	auth := &authConfig{
+		tokens: []string{"token1"},
+		users:  map[string]string{"user1": string(hash)},
+	}
+
+	allow, err := clients.Get("192.168.1.0/24 127.0.0.1")
+	require.Nil(t, err)
+
+	handler := handleAllow(allow, handleAuth(auth, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {},
+	)))
+
+	test := func(remote string, setup func(r *http.Request)) int {
+		r := httptest.NewRequest("GET", "/api", nil)
+		r.RemoteAddr = remote
+		if setup != nil {
+			setup(r)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusForbidden, test("10.0.0.1:1234", nil))
+	require.Equal(t, http.StatusUnauthorized, test("127.0.0.1:1234", nil))
+	require.Equal(t, http.StatusOK, test("192.168.1.5:1234", func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer token1")
+	}))
+	require.Equal(t, http.StatusUnauthorized, test("192.168.1.5:1234", func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer token2")
+	}))
+	require.Equal(t, http.StatusOK, test("127.0.0.1:1234", func(r *http.Request) {
+		r.SetBasicAuth("user1", "pasw1")
+	}))
+	require.Equal(t, http.StatusUnauthorized, test("127.0.0.1:1234", func(r *http.Request) {
+		r.SetBasicAuth("user1", "wrong")
+	}))
+}
@@ -10,6 +10,7 @@ import (
 var stackSkip = [][]byte{
 	// main.go
 	[]byte("main.main()"),
+	[]byte("created by main.main"),
 	[]byte("created by os/signal.Notify"),
 
 	// api/stack.go
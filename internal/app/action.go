@@ -0,0 +1,202 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Action - rule action from config with position for error messages
+type Action struct {
+	Name   string
+	Params url.Values
+
+	File   string
+	Line   int
+	Column int
+}
+
+func (a *Action) UnmarshalYAML(node *yaml.Node) error {
+	return a.UnmarshalNode(node, "action")
+}
+
+// UnmarshalNode parse one-line or structured action form, nameKey - key with action name in structured form
+func (a *Action) UnmarshalNode(node *yaml.Node, nameKey string) (err error) {
+	a.File = nodeFiles[node]
+	a.Line = node.Line
+	a.Column = node.Column
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if raw, ok := rawValues[node]; ok {
+			a.Name, a.Params, err = parseAction(raw, true)
+		} else {
+			a.Name, a.Params, err = ParseAction(node.Value)
+		}
+	case yaml.MappingNode:
+		a.Name, a.Params, err = parseActionNode(node, nameKey)
+	default:
+		err = errors.New("action should be a string or a mapping")
+	}
+
+	if err != nil {
+		return &yaml.TypeError{Errors: []string{a.Errorf("%s", err).Error()}}
+	}
+
+	for _, password := range a.Params["password"] {
+		AddSecret(password)
+	}
+
+	return nil
+}
+
+// parseActionNode parse structured action form. Key "action" is the action name for rules:
+//
+//	action:
+//	  action: proxy_pass
+//	  type: socks5
+//	  host: 123.123.123.123
+//	  port: 1080
+func parseActionNode(node *yaml.Node, nameKey string) (action string, params url.Values, err error) {
+	params = url.Values{}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		switch value.Kind {
+		case yaml.ScalarNode:
+			if key.Value == nameKey {
+				action = value.Value
+			} else {
+				params.Add(key.Value, value.Value)
+			}
+		case yaml.SequenceNode:
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return "", nil, fmt.Errorf("wrong value for param: %s", key.Value)
+				}
+				params.Add(key.Value, item.Value)
+			}
+		default:
+			return "", nil, fmt.Errorf("wrong value for param: %s", key.Value)
+		}
+	}
+
+	if action == "" {
+		return "", nil, errors.New("missing action name in key: " + nameKey)
+	}
+
+	return
+}
+
+// Errorf return error with action position in config file
+func (a *Action) Errorf(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	if a.Line == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", position(a.File, a.Line, a.Column), err)
+}
+
+// ParseAction parse one-line action form: name key1 value1 key2 value2.
+// Values with spaces can be quoted with single or double quotes.
+// Inside double quotes and outside quotes, backslash escapes the next char.
+func ParseAction(raw string) (action string, params url.Values, err error) {
+	return parseAction(raw, false)
+}
+
+// parseAction with expand expands variables after split to fields,
+// so values from variables can't break the action with spaces or quotes
+func parseAction(raw string, expand bool) (action string, params url.Values, err error) {
+	fields, err := splitFields(raw)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if expand {
+		for i, field := range fields {
+			if fields[i], err = expandString(field); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		action = fields[0]
+		params = url.Values{}
+		for i := 1; i < len(fields); i += 2 {
+			k := fields[i]
+			if i+1 == len(fields) {
+				return "", nil, errors.New("no value for param: " + k)
+			}
+			v := fields[i+1]
+			params[k] = append(params[k], v)
+		}
+	}
+
+	return
+}
+
+func splitFields(s string) (fields []string, err error) {
+	var field strings.Builder
+	var inField bool
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				field.WriteByte(c)
+			}
+			continue
+		case c == '\\':
+			if i++; i == len(s) {
+				return nil, errors.New("unexpected end after backslash")
+			}
+			switch c = s[i]; c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			}
+			field.WriteByte(c)
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				field.WriteByte(c)
+			}
+			continue
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+			continue
+		default:
+			field.WriteByte(c)
+		}
+
+		inField = true
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote: %c", quote)
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return
+}
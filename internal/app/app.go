@@ -2,40 +2,35 @@ package app
 
 import (
 	"flag"
-	"net/url"
-	"strings"
+	"path/filepath"
 )
 
 var (
-	Version string
-	Info    = make(map[string]any)
+	Version   string
+	Info      = make(map[string]any)
+	CheckMode bool
 )
 
+var configDir string
+
 func Init() {
 	var configPath string
 
 	flag.StringVar(&configPath, "config", "pnproxy.yaml", "Path to config file")
+	flag.BoolVar(&CheckMode, "check", false, "Check config file and exit")
 	flag.Parse()
 
+	configDir = filepath.Dir(configPath)
+
 	initConfig(configPath)
 	initLog()
+	initState()
 
 	Info["version"] = Version
 	Info["config_path"] = configPath
 }
 
-func ParseAction(raw string) (action string, params url.Values) {
-	fields := strings.Fields(raw)
-
-	if len(fields) > 0 {
-		action = fields[0]
-		params = url.Values{}
-		for i := 1; i < len(fields); i += 2 {
-			k := fields[i]
-			v := fields[i+1]
-			params[k] = append(params[k], v)
-		}
-	}
-
-	return
+// ConfigFile return path to file in config file directory
+func ConfigFile(name string) string {
+	return filepath.Join(configDir, name)
 }
@@ -1,15 +1,289 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestParseAction(t *testing.T) {
-	name, params := ParseAction("static address 192.168.1.123")
+	name, params, err := ParseAction("static address 192.168.1.123")
+	require.Nil(t, err)
 	require.Equal(t, "static", name)
-	require.Equal(t, map[string][]string{
+	require.Equal(t, url.Values{
 		"address": {"192.168.1.123"},
 	}, params)
+
+	_, _, err = ParseAction("static address")
+	require.NotNil(t, err)
+
+	name, params, err = ParseAction(`proxy_pass host 1.2.3.4 password "pa ss#1" username 'u\1' path a\ b`)
+	require.Nil(t, err)
+	require.Equal(t, "proxy_pass", name)
+	require.Equal(t, url.Values{
+		"host":     {"1.2.3.4"},
+		"password": {"pa ss#1"},
+		"username": {`u\1`},
+		"path":     {"a b"},
+	}, params)
+
+	_, _, err = ParseAction(`proxy_pass password "pass`)
+	require.NotNil(t, err)
+}
+
+func TestActionYAML(t *testing.T) {
+	var cfg struct {
+		Action1 Action `yaml:"action1"`
+		Action2 Action `yaml:"action2"`
+	}
+	err := yaml.Unmarshal([]byte(`action1: proxy_pass type socks5 host 1.2.3.4 port 3128
+action2:
+  action: proxy_pass
+  type: socks5
+  host: 1.2.3.4
+  port: 3128
+`), &cfg)
+	require.Nil(t, err)
+	require.Equal(t, cfg.Action1.Name, cfg.Action2.Name)
+	require.Equal(t, cfg.Action1.Params, cfg.Action2.Params)
+
+	err = yaml.Unmarshal([]byte(`action1: {host: 1.2.3.4}`), &cfg)
+	require.EqualError(t, err, "yaml: unmarshal errors:\n  line 1, column 10: missing action name in key: action")
+}
+
+func TestCheckConfig(t *testing.T) {
+	config = &yaml.Node{}
+	err := yaml.Unmarshal([]byte(`dns:
+  listen: ":53"
+  rules:
+    - name: site1.com
+      action: static address
+      typo: 123
+tls:
+  listen: ":443"
+`), config)
+	require.Nil(t, err)
+
+	var cfg struct {
+		DNS struct {
+			Listen string `yaml:"listen"`
+			Rules  []struct {
+				Name   string `yaml:"name"`
+				Action Action `yaml:"action"`
+			} `yaml:"rules"`
+		} `yaml:"dns"`
+	}
+
+	LoadConfig(&cfg)
+
+	require.Equal(t, []string{
+		"line 5, column 15: no value for param: address",
+		`line 6, column 7: unknown key "typo"`,
+		`line 7, column 1: unknown key "tls"`,
+	}, errorsStrings(CheckConfig()))
+}
+
+func errorsStrings(errs []error) (ss []string) {
+	for _, err := range errs {
+		ss = append(ss, err.Error())
+	}
+	return
+}
+
+func TestExpandString(t *testing.T) {
+	t.Setenv("PNPROXY_HOST", "1.2.3.4")
+	t.Setenv("PNPROXY_PASSWORD", "secret1")
+
+	path := t.TempDir() + "/password"
+	err := os.WriteFile(path, []byte("secret2\n"), 0600)
+	require.Nil(t, err)
+
+	s, err := expandString("host ${PNPROXY_HOST} port ${PNPROXY_PORT:-3128} password ${PNPROXY_PASSWORD}")
+	require.Nil(t, err)
+	require.Equal(t, "host 1.2.3.4 port 3128 password secret1", s)
+
+	s, err = expandString("password ${file:" + path + "} escaped $${PNPROXY_HOST}")
+	require.Nil(t, err)
+	require.Equal(t, "password secret2 escaped ${PNPROXY_HOST}", s)
+
+	_, err = expandString("${PNPROXY_UNKNOWN}")
+	require.NotNil(t, err)
+
+	// only file values are secrets, env values are hidden only in password params
+	require.Equal(t, "host 1.2.3.4 secret1 ***", string(Redact([]byte("host 1.2.3.4 secret1 secret2"))))
+}
+
+func TestExpandAction(t *testing.T) {
+	t.Setenv("PNPROXY_PASSWORD", `pa "ss port 1`)
+
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte(`action: proxy_pass host 1.2.3.4 port 3128 password ${PNPROXY_PASSWORD}`), &node)
+	require.Nil(t, err)
+
+	expandNode(&node)
+
+	var cfg struct {
+		Action Action `yaml:"action"`
+	}
+	err = node.Decode(&cfg)
+	require.Nil(t, err)
+	require.Equal(t, []string{"3128"}, cfg.Action.Params["port"])
+	require.Equal(t, []string{`pa "ss port 1`}, cfg.Action.Params["password"])
+	require.Equal(t, "password ***", string(Redact([]byte(`password pa "ss port 1`))))
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		err := os.MkdirAll(filepath.Dir(dir+"/"+name), 0700)
+		require.Nil(t, err)
+		err = os.WriteFile(dir+"/"+name, []byte(data), 0600)
+		require.Nil(t, err)
+	}
+
+	write("pnproxy.yaml", `include: [ hosts.yaml, conf.d, common.yaml, "conf*" ]
+hosts:
+  list1: site1.com
+dns:
+  listen: ":53"
+  rules:
+    - name: list1
+      action: static address 127.0.0.1
+`)
+	write("hosts.yaml", `include: common.yaml
+hosts:
+  list1: site2.com
+  list2: site3.com
+`)
+	write("conf.d/10-dns.yaml", `dns:
+  rules:
+    - name: list2
+      action: static address 127.0.0.2
+`)
+	write("conf.d/20-dns.yaml", `dns:
+  listen: ":5353"
+`)
+	// included twice, but merged once
+	write("common.yaml", `dns:
+  rules:
+    - name: list3
+      action: static address 127.0.0.3
+`)
+
+	root, err := readConfig(dir+"/pnproxy.yaml", nil, map[string]bool{})
+	require.Nil(t, err)
+
+	var cfg struct {
+		Hosts map[string]string `yaml:"hosts"`
+		DNS   struct {
+			Listen string `yaml:"listen"`
+			Rules  []struct {
+				Name string `yaml:"name"`
+			} `yaml:"rules"`
+		} `yaml:"dns"`
+	}
+	err = root.Decode(&cfg)
+	require.Nil(t, err)
+
+	require.Equal(t, map[string]string{"list1": "site2.com", "list2": "site3.com"}, cfg.Hosts)
+	require.Equal(t, ":5353", cfg.DNS.Listen)
+	require.Len(t, cfg.DNS.Rules, 3)
+
+	write("conf.d/30-loop.yaml", `include: ../pnproxy.yaml`)
+	_, err = readConfig(dir+"/pnproxy.yaml", nil, map[string]bool{})
+	require.NotNil(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry[string]()
+	r.Register("proxy_pass", []Param{
+		{Name: "host", Required: true},
+		{Name: "type", Values: []string{"http", "socks5"}},
+	}, func(params url.Values) (string, error) {
+		return params.Get("host"), nil
+	})
+
+	test := func(raw string) (string, error) {
+		action := &Action{}
+		action.Name, action.Params, _ = ParseAction(raw)
+		return r.New(action)
+	}
+
+	s, err := test("proxy_pass host 1.2.3.4 type socks5")
+	require.Nil(t, err)
+	require.Equal(t, "1.2.3.4", s)
+
+	_, err = test("proxy_pas host 1.2.3.4")
+	require.EqualError(t, err, `unknown action: "proxy_pas"`)
+
+	_, err = test("proxy_pass hots 1.2.3.4")
+	require.EqualError(t, err, `proxy_pass: unknown param: "hots"`)
+
+	_, err = test("proxy_pass type http")
+	require.EqualError(t, err, "proxy_pass: missing param: host")
+
+	_, err = test("proxy_pass host 1.2.3.4 type https")
+	require.EqualError(t, err, `proxy_pass: wrong value for param type: "https"`)
+}
+
+func TestMemory(t *testing.T) {
+	statePath = filepath.Join(t.TempDir(), "state.json")
+
+	m := NewMemory[string]("test")
+	m.Set("site1.com", "proxy", time.Hour)
+	m.Set("site2.com", "proxy", -time.Hour)
+
+	v, ok := m.Get("site1.com")
+	require.True(t, ok)
+	require.Equal(t, "proxy", v)
+
+	_, ok = m.Get("site2.com")
+	require.False(t, ok)
+
+	saveState()
+
+	// restore from file
+	stateData = map[string]json.RawMessage{}
+	b, err := os.ReadFile(statePath)
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(b, &stateData))
+
+	m = NewMemory[string]("test")
+	_, ok = m.Get("site1.com")
+	require.True(t, ok)
+
+	require.True(t, ResetState("test", ""))
+	_, ok = m.Get("site1.com")
+	require.False(t, ok)
+	require.False(t, ResetState("test2", ""))
+}
+
+func TestStartRollback(t *testing.T) {
+	defer func() { hooks = nil }()
+
+	var stopped []string
+	for _, name := range []string{"dns", "tls", "http"} {
+		name := name
+		OnStart(name, func() error {
+			if name == "http" {
+				return errors.New("address already in use")
+			}
+			return nil
+		})
+		OnStop(name, func(context.Context) error {
+			stopped = append(stopped, name)
+			return nil
+		})
+	}
+
+	require.NotNil(t, Start())
+	require.Equal(t, []string{"tls", "dns"}, stopped)
 }
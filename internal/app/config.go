@@ -1,23 +1,142 @@
 package app
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 func LoadConfig(v any) {
-	if err := yaml.Unmarshal(config, v); err != nil {
-		log.Error().Err(err).Caller().Send()
+	if config == nil {
+		return
 	}
+
+	addKnownFields("", reflect.TypeOf(v))
+
+	if err := config.Decode(v); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, s := range typeErr.Errors {
+				ConfigError(errors.New(s))
+			}
+		} else {
+			ConfigError(err)
+		}
+	}
+}
+
+// ConfigError save config error for check mode and print it to log
+func ConfigError(err error) {
+	for _, e := range configErrors {
+		if e.Error() == err.Error() {
+			return
+		}
+	}
+	configErrors = append(configErrors, err)
+	log.Error().Msgf("[config] %s", err)
+}
+
+// CheckConfig report unknown config keys and return all config errors
+func CheckConfig() []error {
+	if config != nil {
+		checkKnownFields("", config)
+	}
+	return configErrors
 }
 
-var config []byte
+var config *yaml.Node
+var configErrors []error
 
 func initConfig(fileName string) {
-	var err error
-	if config, err = os.ReadFile(fileName); err != nil {
-		log.Error().Err(err).Caller().Send()
+	root, err := readConfig(fileName, nil, map[string]bool{})
+	if err != nil {
+		ConfigError(err)
+		return
+	}
+
+	expandNode(root)
+	config = root
+}
+
+// knownFields - all config paths used by modules, value true for leaf paths
+var knownFields = map[string]bool{}
+
+func addKnownFields(path string, typ reflect.Type) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	// types with custom unmarshaler and any types are leafs
+	if typ.Kind() == reflect.Interface || reflect.PointerTo(typ).Implements(unmarshalerType) {
+		knownFields[path] = true
+		return
+	}
+
+	if _, ok := knownFields[path]; !ok {
+		knownFields[path] = false
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			switch {
+			case name == "-":
+				continue
+			case strings.Contains(opts, "inline"):
+				addKnownFields(path, field.Type)
+				continue
+			case name == "":
+				name = strings.ToLower(field.Name)
+			}
+			addKnownFields(path+"."+name, field.Type)
+		}
+	case reflect.Map:
+		addKnownFields(path+".*", typ.Elem())
+	case reflect.Slice, reflect.Array:
+		addKnownFields(path+"[]", typ.Elem())
+	}
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+func checkKnownFields(path string, node *yaml.Node) {
+	if knownFields[path] {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			checkKnownFields(path, child)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			var childPath string
+			if _, ok := knownFields[path+".*"]; ok {
+				childPath = path + ".*"
+			} else if _, ok = knownFields[path+"."+key.Value]; ok {
+				childPath = path + "." + key.Value
+			} else {
+				ConfigError(fmt.Errorf("%s: unknown key %q", nodePosition(key), key.Value))
+				continue
+			}
+
+			checkKnownFields(childPath, value)
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			checkKnownFields(path+"[]", child)
+		}
 	}
 }
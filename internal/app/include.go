@@ -0,0 +1,187 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readConfig read config file with all included files and return root mapping node.
+// Parents and visited are absolute paths, so each file is merged only once.
+func readConfig(path string, parents []string, visited map[string]bool) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	visited[abs] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: config should be a mapping", path)
+	}
+
+	// remember file name for error messages, except main config file
+	if parents != nil {
+		addNodeFile(root, path)
+	}
+
+	if i := findKey(root, "include"); i >= 0 {
+		node := root.Content[i+1]
+		root.Content = slices.Delete(root.Content, i, i+2)
+
+		var patterns []string
+		if err = node.Decode(&patterns); err != nil {
+			var pattern string
+			if err = node.Decode(&pattern); err != nil {
+				return nil, fmt.Errorf("%s: include should be a string or a list", nodePosition(node))
+			}
+			patterns = []string{pattern}
+		}
+
+		for _, pattern := range patterns {
+			files, err := includeFiles(filepath.Dir(path), pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", nodePosition(node), err)
+			}
+
+			for _, file := range files {
+				fileAbs, err := filepath.Abs(file)
+				if err != nil {
+					return nil, err
+				}
+				if fileAbs == abs || slices.Contains(parents, fileAbs) {
+					return nil, fmt.Errorf("%s: include loop: %s", nodePosition(node), file)
+				}
+				// same file from several includes
+				if visited[fileAbs] {
+					continue
+				}
+
+				child, err := readConfig(file, append(parents, abs), visited)
+				if err != nil {
+					return nil, err
+				}
+
+				mergeNode(root, child)
+			}
+		}
+	}
+
+	return root, nil
+}
+
+// includeFiles return sorted list of files for path, dir or glob pattern relative to dir
+func includeFiles(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	if info, err := os.Stat(pattern); err == nil {
+		if !info.IsDir() {
+			return []string{pattern}, nil
+		}
+
+		entries, err := os.ReadDir(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		var files []string
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() && (strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")) {
+				files = append(files, filepath.Join(pattern, name))
+			}
+		}
+		return files, nil // ReadDir return sorted entries
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if matches == nil && !strings.ContainsAny(pattern, "*?[") {
+		return nil, errors.New("file not found: " + pattern)
+	}
+
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// mergeNode merge src to dst: mappings merged, lists appended, other values replaced
+func mergeNode(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		j := findKey(dst, key.Value)
+		if j < 0 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+
+		switch old := dst.Content[j+1]; {
+		case old.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNode(old, value)
+		case old.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			old.Content = append(old.Content, value.Content...)
+		default:
+			dst.Content[j+1] = value
+		}
+	}
+}
+
+func findKey(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+var nodeFiles = map[*yaml.Node]string{}
+
+func addNodeFile(node *yaml.Node, path string) {
+	nodeFiles[node] = path
+	for _, child := range node.Content {
+		addNodeFile(child, path)
+	}
+}
+
+// nodePosition return node position in config with file name for included files
+func nodePosition(node *yaml.Node) string {
+	return position(nodeFiles[node], node.Line, node.Column)
+}
+
+func position(file string, line, column int) string {
+	if file != "" {
+		return fmt.Sprintf("%s: line %d, column %d", file, line, column)
+	}
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
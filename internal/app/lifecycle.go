@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type hook struct {
+	name  string
+	start func() error
+	stop  func(ctx context.Context) error
+}
+
+var hooks []hook
+
+// OnStart add function that will be called after init of all modules
+func OnStart(name string, fn func() error) {
+	hooks = append(hooks, hook{name: name, start: fn})
+}
+
+// OnStop add function that will be called on app shutdown.
+// All stop functions are called simultaneously and should return after ctx done.
+func OnStop(name string, fn func(ctx context.Context) error) {
+	hooks = append(hooks, hook{name: name, stop: fn})
+}
+
+// Start call all start functions in registration order. On first error already started
+// modules are stopped in reverse order.
+func Start() error {
+	names := map[string]bool{}
+
+	for _, h := range hooks {
+		if h.start == nil {
+			continue
+		}
+		if err := h.start(); err != nil {
+			ModuleStatus(h.name, err)
+			stopModules(names)
+			return fmt.Errorf("[%s] %w", h.name, err)
+		}
+		ModuleStatus(h.name, nil)
+		names[h.name] = true
+	}
+
+	statusMu.Lock()
+	started = true
+	statusMu.Unlock()
+
+	return nil
+}
+
+// Stop call all stop functions and return all their errors
+func Stop(ctx context.Context) error {
+	statusMu.Lock()
+	started = false
+	statusMu.Unlock()
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	for _, h := range hooks {
+		if h.stop == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(h hook) {
+			defer wg.Done()
+
+			if err := h.stop(ctx); err != nil {
+				log.Error().Err(err).Msgf("[%s] stop", h.name)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("[%s] %w", h.name, err))
+				mu.Unlock()
+			} else {
+				log.Debug().Msgf("[%s] stopped", h.name)
+				setState(h.name, StateStopped, "")
+			}
+		}(h)
+	}
+
+	wg.Wait()
+
+	saveState()
+
+	return errors.Join(errs...)
+}
+
+// stopModules call stop functions of started modules one by one in reverse order
+func stopModules(names map[string]bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if h.stop == nil || !names[h.name] {
+			continue
+		}
+		if err := h.stop(ctx); err != nil {
+			log.Error().Err(err).Msgf("[%s] stop", h.name)
+		}
+	}
+}
+
+const shutdownTimeout = 5 * time.Second
+
+// ShutdownServer gracefully shutdown HTTP server or close it after timeout
+func ShutdownServer(ctx context.Context, srv *http.Server) error {
+	ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		_ = srv.Close()
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"os"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -18,9 +19,12 @@ func initLog() {
 
 	LoadConfig(&cfg)
 
+	// hide secrets from config in all logs
+	log.Logger = log.Output(RedactWriter{os.Stderr})
+
 	lvl, err := zerolog.ParseLevel(cfg.Log.Level)
 	if err != nil {
-		log.Warn().Err(err).Caller().Send()
+		ConfigError(err)
 		return
 	}
 
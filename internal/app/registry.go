@@ -0,0 +1,92 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// Param - action param schema
+type Param struct {
+	Name     string
+	Required bool
+	Values   []string // allowed values, any value if empty
+}
+
+// Registry - module actions with params schema and handler constructors
+type Registry[T any] struct {
+	actions map[string]*registryAction[T]
+}
+
+type registryAction[T any] struct {
+	params []Param
+	new    func(params url.Values) (T, error)
+}
+
+func NewRegistry[T any]() *Registry[T] {
+	return &Registry[T]{actions: map[string]*registryAction[T]{}}
+}
+
+// Register add action with params schema and handler constructor
+func (r *Registry[T]) Register(name string, params []Param, fn func(params url.Values) (T, error)) {
+	r.actions[name] = &registryAction[T]{params: params, new: fn}
+}
+
+// Extend register action from base registry in r. Params are base action params and own params.
+// Handler is created from base action result, so all modules share one action definition.
+func Extend[T, B any](r *Registry[T], base *Registry[B], name string, params []Param, fn func(params url.Values, b B) (T, error)) {
+	a := base.actions[name]
+	r.Register(name, slices.Concat(a.params, params), func(values url.Values) (handler T, err error) {
+		b, err := a.new(values)
+		if err != nil {
+			return handler, err
+		}
+		return fn(values, b)
+	})
+}
+
+// New check action params with schema and create handler
+func (r *Registry[T]) New(action *Action) (handler T, err error) {
+	a, ok := r.actions[action.Name]
+	if !ok {
+		return handler, fmt.Errorf("unknown action: %q", action.Name)
+	}
+
+	if err = checkParams(action.Params, a.params); err != nil {
+		return handler, fmt.Errorf("%s: %w", action.Name, err)
+	}
+
+	if handler, err = a.new(action.Params); err != nil {
+		return handler, fmt.Errorf("%s: %w", action.Name, err)
+	}
+
+	return handler, nil
+}
+
+func checkParams(params url.Values, schema []Param) error {
+	for name := range params {
+		if !slices.ContainsFunc(schema, func(p Param) bool { return p.Name == name }) {
+			return fmt.Errorf("unknown param: %q", name)
+		}
+	}
+
+	for _, p := range schema {
+		values, ok := params[p.Name]
+		if !ok {
+			if p.Required {
+				return fmt.Errorf("missing param: %s", p.Name)
+			}
+			continue
+		}
+
+		if p.Values != nil {
+			for _, value := range values {
+				if !slices.Contains(p.Values, value) {
+					return fmt.Errorf("wrong value for param %s: %q", p.Name, value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
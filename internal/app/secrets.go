@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// expandNode replace ${ENV_VAR}, ${ENV_VAR:-default} and ${file:/path} in all scalar values
+func expandNode(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+
+		value, err := expandString(node.Value)
+		if err != nil {
+			ConfigError(fmt.Errorf("%s: %w", nodePosition(node), err))
+			return
+		}
+
+		rawValues[node] = node.Value
+		node.Value = value
+		if node.Style == 0 {
+			node.Tag = "" // resolve type again, for example for numbers
+		}
+		return
+	}
+
+	for _, child := range node.Content {
+		expandNode(child)
+	}
+}
+
+// rawValues - scalar values before expand, one-line actions are expanded after split to fields
+var rawValues = map[*yaml.Node]string{}
+
+func expandString(s string) (string, error) {
+	var b strings.Builder
+
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+
+		// $${ - escaped value
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return "", fmt.Errorf("unterminated variable: %s", s[i:])
+		}
+
+		value, err := expandVar(s[i+2 : i+j])
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[i+j+1:]
+	}
+
+	b.WriteString(s)
+
+	return b.String(), nil
+}
+
+func expandVar(name string) (string, error) {
+	if path, ok := strings.CutPrefix(name, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		AddSecret(value)
+		return value, nil
+	}
+
+	name, def, hasDef := strings.Cut(name, ":-")
+
+	value, ok := os.LookupEnv(name)
+	if !ok || (value == "" && hasDef) {
+		if !hasDef {
+			return "", fmt.Errorf("env variable not set: %s", name)
+		}
+		value = def
+	}
+
+	return value, nil
+}
+
+var secrets [][]byte
+var secretsMu sync.RWMutex
+
+// AddSecret add value that will be hidden in logs and API output
+func AddSecret(value string) {
+	if len(value) < 3 {
+		return // too short values will hide too much
+	}
+
+	// JSON-escaped value for logs
+	b, _ := json.Marshal(value)
+	b = b[1 : len(b)-1]
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, secret := range secrets {
+		if string(secret) == value {
+			return
+		}
+	}
+
+	secrets = append(secrets, []byte(value))
+	if string(b) != value {
+		secrets = append(secrets, b)
+	}
+}
+
+var redacted = []byte("***")
+
+// Redact replace all secrets in data
+func Redact(data []byte) []byte {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		if bytes.Contains(data, secret) {
+			data = bytes.ReplaceAll(data, secret, redacted)
+		}
+	}
+	secretsMu.RUnlock()
+	return data
+}
+
+// RedactWriter replace all secrets before write
+type RedactWriter struct {
+	io.Writer
+}
+
+func (w RedactWriter) Write(p []byte) (n int, err error) {
+	if _, err = w.Writer.Write(Redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
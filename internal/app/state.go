@@ -0,0 +1,198 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Memory - learned per-key decisions of modules with expiry, saved to state file
+type Memory[T any] struct {
+	mu    sync.Mutex
+	items map[string]MemoryItem[T]
+}
+
+type MemoryItem[T any] struct {
+	Value   T         `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewMemory create memory with name in state file and restore it from file
+func NewMemory[T any](name string) *Memory[T] {
+	m := &Memory[T]{items: map[string]MemoryItem[T]{}}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if raw, ok := stateData[name]; ok {
+		if err := json.Unmarshal(raw, &m.items); err != nil {
+			log.Warn().Err(err).Msgf("[state] restore name=%s", name)
+		}
+	}
+
+	memories[name] = m
+
+	return m
+}
+
+// Get return value if it is not expired
+func (m *Memory[T]) Get(key string) (value T, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[key]
+	if !ok {
+		return
+	}
+	if time.Now().After(item.Expires) {
+		delete(m.items, key)
+		return value, false
+	}
+	return item.Value, true
+}
+
+// Set save value for TTL time
+func (m *Memory[T]) Set(key string, value T, ttl time.Duration) {
+	m.mu.Lock()
+	m.items[key] = MemoryItem[T]{Value: value, Expires: time.Now().Add(ttl)}
+	m.mu.Unlock()
+
+	saveStateLater()
+}
+
+// Delete remove one key or all keys if key is empty
+func (m *Memory[T]) Delete(key string) {
+	m.mu.Lock()
+	if key == "" {
+		clear(m.items)
+	} else {
+		delete(m.items, key)
+	}
+	m.mu.Unlock()
+
+	saveStateLater()
+}
+
+func (m *Memory[T]) marshal() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, item := range m.items {
+		if now.After(item.Expires) {
+			delete(m.items, key)
+		}
+	}
+
+	return json.Marshal(m.items)
+}
+
+type memory interface {
+	marshal() ([]byte, error)
+	Delete(key string)
+}
+
+// States return all memories for API
+func States() map[string]json.RawMessage {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	states := make(map[string]json.RawMessage, len(memories))
+	for name, m := range memories {
+		if b, err := m.marshal(); err == nil {
+			states[name] = b
+		}
+	}
+	return states
+}
+
+// ResetState remove key from memory or all keys if key is empty, return false for unknown memory
+func ResetState(name, key string) bool {
+	stateMu.Lock()
+	m, ok := memories[name]
+	stateMu.Unlock()
+
+	if ok {
+		m.Delete(key)
+	}
+	return ok
+}
+
+const stateSaveDelay = 10 * time.Second
+
+var (
+	statePath  string
+	stateData  = map[string]json.RawMessage{}
+	stateTimer *time.Timer
+	stateMu    sync.Mutex
+	memories   = map[string]memory{}
+)
+
+func initState() {
+	var cfg struct {
+		StateFile string `yaml:"state_file"`
+	}
+
+	cfg.StateFile = ConfigFile("pnproxy.state.json")
+
+	LoadConfig(&cfg)
+
+	statePath = cfg.StateFile
+
+	b, err := os.ReadFile(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn().Err(err).Msgf("[state] read")
+		}
+		return
+	}
+
+	if err = json.Unmarshal(b, &stateData); err != nil {
+		log.Warn().Err(err).Msgf("[state] read")
+	}
+}
+
+func saveStateLater() {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if stateTimer == nil && !CheckMode {
+		stateTimer = time.AfterFunc(stateSaveDelay, saveState)
+	}
+}
+
+// saveState write all memories to state file, if there were any changes
+func saveState() {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if stateTimer == nil {
+		return
+	}
+	stateTimer.Stop()
+	stateTimer = nil
+
+	for name, m := range memories {
+		if b, err := m.marshal(); err == nil {
+			stateData[name] = b
+		}
+	}
+
+	b, err := json.MarshalIndent(stateData, "", "  ")
+	if err != nil {
+		log.Warn().Err(err).Msgf("[state] save")
+		return
+	}
+
+	// write to temp file and rename, so the file is never half written
+	tmp := statePath + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o644); err == nil {
+		err = os.Rename(tmp, statePath)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msgf("[state] save")
+	}
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StateRunning = "running"
+	StateFailed  = "failed"
+	StateStopped = "stopped"
+)
+
+type Status struct {
+	State string    `json:"state"`
+	Error string    `json:"error,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+var (
+	statuses = map[string]*Status{}
+	statusMu sync.Mutex
+	started  bool
+)
+
+// ModuleStatus update module status, nil error means module is running
+func ModuleStatus(name string, err error) {
+	if err != nil {
+		setState(name, StateFailed, err.Error())
+	} else {
+		setState(name, StateRunning, "")
+	}
+}
+
+func setState(name, state, error string) {
+	statusMu.Lock()
+	statuses[name] = &Status{State: state, Error: error, Since: time.Now()}
+	statusMu.Unlock()
+}
+
+// Statuses return copy of all modules statuses
+func Statuses() map[string]Status {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	m := make(map[string]Status, len(statuses))
+	for name, status := range statuses {
+		m[name] = *status
+	}
+	return m
+}
+
+// Healthy return true if app started and all modules running
+func Healthy() bool {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	if !started {
+		return false
+	}
+	for _, status := range statuses {
+		if status.State != StateRunning {
+			return false
+		}
+	}
+	return true
+}
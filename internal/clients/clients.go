@@ -0,0 +1,83 @@
+package clients
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+)
+
+func Init() {
+	var cfg struct {
+		Clients map[string]string `yaml:"clients"`
+	}
+
+	app.LoadConfig(&cfg)
+
+	for alias, aliases := range cfg.Clients {
+		nets, err := parseNets(aliases)
+		if err != nil {
+			app.ConfigError(err)
+			continue
+		}
+		clients[alias] = nets
+	}
+}
+
+// Get convert list of client groups, IP-addresses and networks to networks
+func Get(aliases string) (nets []*net.IPNet, err error) {
+	for _, alias := range strings.Fields(aliases) {
+		if group, ok := clients[alias]; ok {
+			nets = append(nets, group...)
+			continue
+		}
+
+		ipnets, err := parseNets(alias)
+		if err != nil {
+			return nil, errors.New("unknown client group or address: " + alias)
+		}
+		nets = append(nets, ipnets...)
+	}
+	return
+}
+
+// Contains check if client address (with or without port) is in networks
+func Contains(nets []*net.IPNet, address string) bool {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNets(s string) (nets []*net.IPNet, err error) {
+	for _, field := range strings.Fields(s) {
+		if !strings.Contains(field, "/") {
+			if strings.Contains(field, ":") {
+				field += "/128"
+			} else {
+				field += "/32"
+			}
+		}
+
+		var ipnet *net.IPNet
+		if _, ipnet, err = net.ParseCIDR(field); err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return
+}
+
+var clients = map[string][]*net.IPNet{}
@@ -3,12 +3,15 @@ package dns
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
 	"net/url"
+	"slices"
 	"time"
 
 	"github.com/AlexxIT/pnproxy/internal/app"
 	"github.com/AlexxIT/pnproxy/internal/hosts"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
 	"github.com/miekg/dns"
 	"github.com/rs/zerolog/log"
 )
@@ -16,46 +19,65 @@ import (
 func Init() {
 	var cfg struct {
 		DNS struct {
-			Listen string `yaml:"listen"`
-			Rules  []struct {
-				Name   string `yaml:"name"`
-				Action string `yaml:"action"`
+			Listen   string `yaml:"listen"`
+			StripECH bool   `yaml:"strip_ech"`
+			Rules    []struct {
+				Name   string     `yaml:"name"`
+				Action app.Action `yaml:"action"`
 			} `yaml:"rules"`
 			Default struct {
-				Action string `yaml:"action"`
+				Action app.Action `yaml:"action"`
 			} `yaml:"default"`
 		} `yaml:"dns"`
 	}
 
+	initActions()
+
 	app.LoadConfig(&cfg)
 
 	for _, rule := range cfg.DNS.Rules {
-		action, params := app.ParseAction(rule.Action)
-		switch action {
-		case "static":
-			domains := hosts.Get(rule.Name)
-			log.Debug().Msgf("[dns] static address for %s", domains)
-			for _, domain := range domains {
-				addStaticIP(domain, params["address"])
-			}
-		default:
-			log.Warn().Msgf("[dns] unknown action: %s", action)
+		handler, err := actions.New(&rule.Action)
+		if err != nil {
+			app.ConfigError(rule.Action.Errorf("[dns] %w", err))
+			continue
 		}
+
+		handler(hosts.Get(rule.Name))
 	}
 
-	if dial := parseDefaultAction(cfg.DNS.Default.Action); dial != nil {
-		net.DefaultResolver.PreferGo = true
-		net.DefaultResolver.Dial = dial
+	if cfg.DNS.Default.Action.Name != "" {
+		if dial, err := defaultActions.New(&cfg.DNS.Default.Action); err != nil {
+			app.ConfigError(cfg.DNS.Default.Action.Errorf("[dns] %w", err))
+		} else {
+			net.DefaultResolver.PreferGo = true
+			net.DefaultResolver.Dial = dial
+			resolverDial = dial
+		}
 	}
 
+	stripECH = cfg.DNS.StripECH
+
 	if cfg.DNS.Listen != "" {
-		go serve(cfg.DNS.Listen)
+		server := &dns.Server{Addr: cfg.DNS.Listen, Net: "udp"}
+		app.OnStart("dns", func() error {
+			conn, err := net.ListenPacket("udp", server.Addr)
+			if err != nil {
+				return err
+			}
+			server.PacketConn = conn
+			go serve(server)
+			return nil
+		})
+		app.OnStop("dns", func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			return server.ShutdownContext(ctx)
+		})
 	}
 }
 
-func serve(address string) {
-	log.Info().Msgf("[dns] listen=%s", address)
-	server := &dns.Server{Addr: address, Net: "udp"}
+func serve(server *dns.Server) {
+	log.Info().Msgf("[dns] listen=%s", server.Addr)
 	server.Handler = dns.HandlerFunc(func(wr dns.ResponseWriter, msg *dns.Msg) {
 		m := &dns.Msg{}
 		m.SetReply(msg)
@@ -67,14 +89,18 @@ func serve(address string) {
 		_ = wr.WriteMsg(m)
 	})
 
-	if err := server.ListenAndServe(); err != nil {
+	if err := server.ActivateAndServe(); err != nil {
 		log.Error().Err(err).Caller().Send()
+		app.ModuleStatus("dns", err)
 	}
 }
 
 func parseQuery(query *dns.Msg) {
 	for _, question := range query.Question {
-		if question.Qtype == dns.TypeA {
+		switch question.Qtype {
+		case dns.TypeHTTPS, dns.TypeSVCB:
+			query.Answer = append(query.Answer, lookupHTTPS(question)...)
+		case dns.TypeA:
 			ips, _ := lookupStaticIP(question.Name)
 
 			if ips == nil {
@@ -99,41 +125,87 @@ func parseQuery(query *dns.Msg) {
 	}
 }
 
+// ruleFunc - apply rule action for list of domains
+type ruleFunc func(domains []string)
+
 type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
 
-func parseDefaultAction(raw string) dialFunc {
-	if raw != "" {
-		action, params := app.ParseAction(raw)
-		switch action {
-		case "dns":
-			return dialDNS(params)
-		case "doh":
-			return dialDOH(params)
-		case "dot":
-			return dialDOT(params)
+var actions = app.NewRegistry[ruleFunc]()
+var defaultActions = app.NewRegistry[dialFunc]()
+
+func initActions() {
+	actions.Register("static", []app.Param{
+		{Name: "address", Required: true},
+		{Name: "https", Values: []string{"drop", "rewrite"}},
+	}, handleStatic)
+
+	// servers can be used via upstreams, like raw_pass action in other modules
+	serverParams := slices.Concat([]app.Param{
+		{Name: "server"}, {Name: "provider"},
+	}, upstream.DirectParams)
+	defaultActions.Register("dns", serverParams, withServer(dialDNS))
+	defaultActions.Register("doh", serverParams, withServer(dialDOH))
+	defaultActions.Register("dot", serverParams, withServer(dialDOT))
+}
+
+func handleStatic(params url.Values) (ruleFunc, error) {
+	addrs := params["address"]
+	if err := checkStaticIP(addrs); err != nil {
+		return nil, err
+	}
+
+	https := params.Get("https")
+
+	return func(domains []string) {
+		log.Debug().Msgf("[dns] static address for %s", domains)
+		for _, domain := range domains {
+			addStaticIP(domain, addrs, https)
+		}
+	}, nil
+}
+
+// withServer check server or provider param before creating dial function
+func withServer(dial func(params url.Values, dialer upstream.Dialer) dialFunc) func(params url.Values) (dialFunc, error) {
+	return func(params url.Values) (dialFunc, error) {
+		if server(params) == "" {
+			return nil, errors.New("wrong server or provider")
 		}
+		dialer, err := upstream.Direct(params)
+		if err != nil {
+			return nil, err
+		}
+		return dial(params, dialer), nil
 	}
-	return nil
 }
 
-func dialDNS(params url.Values) dialFunc {
-	dialer := net.Dialer{Timeout: 5 * time.Second}
+func dialDNS(params url.Values, dialer upstream.Dialer) dialFunc {
 	address := server(params) + ":53"
+
+	// upstreams support only TCP
+	network := "udp"
+	if params.Has("via") {
+		network = "tcp"
+	}
+
 	return func(ctx context.Context, _, _ string) (net.Conn, error) {
-		return dialer.DialContext(ctx, "udp", address)
+		return dialer.DialContext(ctx, network, address)
 	}
 }
 
-func dialDOT(params url.Values) dialFunc {
-	dialer := tls.Dialer{NetDialer: &net.Dialer{Timeout: 5 * time.Second}}
-	address := server(params) + ":853"
+func dialDOT(params url.Values, dialer upstream.Dialer) dialFunc {
+	host := server(params)
+	address := host + ":853"
 	return func(ctx context.Context, _, _ string) (net.Conn, error) {
-		return dialer.DialContext(ctx, "tcp", address)
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return tls.Client(conn, &tls.Config{ServerName: host}), nil
 	}
 }
 
-func dialDOH(params url.Values) dialFunc {
-	conn := newDoHConn(server(params))
+func dialDOH(params url.Values, dialer upstream.Dialer) dialFunc {
+	conn := newDoHConn(server(params), dialer)
 	return func(ctx context.Context, network, address string) (net.Conn, error) {
 		return conn, nil
 	}
@@ -8,19 +8,26 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/upstream"
 )
 
 type dohConn struct {
 	server   string
+	client   *http.Client
 	deadline time.Time
 	pool     sync.Pool
 }
 
-func newDoHConn(server string) *dohConn {
+func newDoHConn(server string, dialer upstream.Dialer) *dohConn {
 	if net.ParseIP(server) != nil {
 		server = "https://" + server + "/dns-query"
 	}
-	return &dohConn{server: server}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	return &dohConn{server: server, client: &http.Client{Transport: transport}}
 }
 
 func (d *dohConn) Read(b []byte) (n int, err error) {
@@ -93,7 +100,7 @@ func (d *dohConn) query(b []byte) ([]byte, error) {
 	req.Header.Set("Accept", "application/dns-message")
 	req.Header.Set("Content-Type", "application/dns-message")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := d.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,195 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	itls "github.com/AlexxIT/pnproxy/internal/tls"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+var stripECH bool
+
+// lookupHTTPS forward HTTPS or SVCB question to upstream and change records for routed domains
+func lookupHTTPS(question dns.Question) []dns.RR {
+	record := lookupStatic(question.Name)
+	if record == nil {
+		if !stripECH {
+			return nil
+		}
+	} else if record.https == "drop" || record.https == "" && !stripECH {
+		// static domains without https param are rewritten only with strip_ech
+		return nil
+	}
+
+	answer, err := exchange(question)
+	if err != nil {
+		log.Debug().Err(err).Msgf("[dns] lookup name=%s type=%d", question.Name, question.Qtype)
+		return nil
+	}
+
+	// origin address hints and h3 of static domains bypass rule addresses
+	if record != nil {
+		rewriteHTTPS(answer, record.ips)
+	} else if itls.Covered(question.Name) {
+		removeECH(answer)
+	}
+
+	return answer
+}
+
+// rewriteHTTPS replace address hints with static addresses and remove h3 and ech params,
+// so clients connect to pnproxy over TCP
+func rewriteHTTPS(answer []dns.RR, ips []net.IP) {
+	var ipv4, ipv6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, ip)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+
+	for _, rr := range answer {
+		// AliasMode record (priority 0) has no params
+		svcb := svcbRecord(rr)
+		if svcb == nil || svcb.Priority == 0 {
+			continue
+		}
+
+		var value []dns.SVCBKeyValue
+		for _, kv := range svcb.Value {
+			switch kv := kv.(type) {
+			case *dns.SVCBAlpn:
+				kv.Alpn = slices.DeleteFunc(kv.Alpn, func(s string) bool {
+					return s == "h3" || strings.HasPrefix(s, "h3-")
+				})
+				if len(kv.Alpn) == 0 {
+					continue
+				}
+			case *dns.SVCBECHConfig, *dns.SVCBIPv4Hint, *dns.SVCBIPv6Hint:
+				continue
+			}
+			value = append(value, kv)
+		}
+
+		if ipv4 != nil {
+			value = append(value, &dns.SVCBIPv4Hint{Hint: ipv4})
+		}
+		if ipv6 != nil {
+			value = append(value, &dns.SVCBIPv6Hint{Hint: ipv6})
+		}
+
+		svcb.Value = cleanupKeys(value)
+	}
+}
+
+// cleanupKeys remove no-default-alpn without alpn and mandatory keys that were removed
+func cleanupKeys(value []dns.SVCBKeyValue) []dns.SVCBKeyValue {
+	keys := map[dns.SVCBKey]bool{}
+	for _, kv := range value {
+		keys[kv.Key()] = true
+	}
+
+	return slices.DeleteFunc(value, func(kv dns.SVCBKeyValue) bool {
+		switch kv := kv.(type) {
+		case *dns.SVCBNoDefaultAlpn:
+			return !keys[dns.SVCB_ALPN]
+		case *dns.SVCBMandatory:
+			kv.Code = slices.DeleteFunc(kv.Code, func(key dns.SVCBKey) bool {
+				return !keys[key]
+			})
+			return len(kv.Code) == 0
+		}
+		return false
+	})
+}
+
+// removeECH remove ech param from HTTPS and SVCB records, so clients use plain ClientHello
+func removeECH(answer []dns.RR) {
+	for _, rr := range answer {
+		if svcb := svcbRecord(rr); svcb != nil {
+			svcb.Value = cleanupKeys(slices.DeleteFunc(svcb.Value, func(kv dns.SVCBKeyValue) bool {
+				return kv.Key() == dns.SVCB_ECHCONFIG
+			}))
+		}
+	}
+}
+
+func svcbRecord(rr dns.RR) *dns.SVCB {
+	switch rr := rr.(type) {
+	case *dns.HTTPS:
+		return &rr.SVCB
+	case *dns.SVCB:
+		return rr
+	}
+	return nil
+}
+
+const exchangeTimeout = 5 * time.Second
+
+// resolverDial - dial function of default action, system DNS server if empty
+var resolverDial dialFunc
+
+func exchange(question dns.Question) ([]dns.RR, error) {
+	dial := resolverDial
+	if dial == nil {
+		dial = systemDial
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exchangeTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, "udp", "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(exchangeTimeout))
+
+	req := &dns.Msg{}
+	req.SetQuestion(question.Name, question.Qtype)
+
+	co := &dns.Conn{Conn: conn}
+	if err = co.WriteMsg(req); err != nil {
+		return nil, err
+	}
+
+	res, err := co.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if res.Id != req.Id {
+		return nil, errors.New("dns: wrong response id")
+	}
+
+	return res.Answer, nil
+}
+
+// systemDial dial the first server of the platform resolver, Go resolver reads it on all OS
+func systemDial(ctx context.Context, network, _ string) (net.Conn, error) {
+	var server string
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(_ context.Context, _, address string) (net.Conn, error) {
+			if server == "" {
+				server = address
+			}
+			return nil, errors.New("dns: stop lookup")
+		},
+	}
+	_, _ = resolver.LookupTXT(ctx, "example.com")
+
+	if server == "" {
+		return nil, errors.New("dns: no system servers")
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, server)
+}
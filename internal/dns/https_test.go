@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupHTTPS(t *testing.T) {
+	testResolver(t, `example.com. 300 IN HTTPS 1 . alpn="h3,h2" ech="AEX+DQBBAQAgACCA" ipv4hint="1.2.3.4"`)
+
+	answer, err := exchange(dns.Question{Name: "example.com.", Qtype: dns.TypeHTTPS, Qclass: dns.ClassINET})
+	require.Nil(t, err)
+	require.Len(t, answer, 1)
+	require.Contains(t, answer[0].String(), "ech=")
+
+	removeECH(answer)
+	require.NotContains(t, answer[0].String(), "ech=")
+	require.Contains(t, answer[0].String(), `alpn="h3,h2"`)
+}
+
+func TestLookupStaticHTTPS(t *testing.T) {
+	testResolver(t, `example.com. 300 IN HTTPS 1 . alpn="h3,h2" ech="AEX+DQBBAQAgACCA" ipv4hint="1.2.3.4"`)
+
+	defer func() { static, stripECH = map[string]*staticRecord{}, false }()
+
+	question := dns.Question{Name: "example.com.", Qtype: dns.TypeHTTPS, Qclass: dns.ClassINET}
+
+	// without strip_ech records are not forwarded
+	addStaticIP("example.com", []string{"192.168.1.123"}, "")
+	require.Nil(t, lookupHTTPS(question))
+
+	// static domain without https param is rewritten with strip_ech
+	stripECH = true
+	answer := lookupHTTPS(question)
+	require.Len(t, answer, 1)
+	require.Equal(t, "example.com.\t300\tIN\tHTTPS\t1 . alpn=\"h2\" ipv4hint=\"192.168.1.123\"", answer[0].String())
+
+	// drop works with strip_ech
+	addStaticIP("example.com", []string{"192.168.1.123"}, "drop")
+	require.Nil(t, lookupHTTPS(question))
+
+	// rewrite works without strip_ech
+	stripECH = false
+	addStaticIP("example.com", []string{"192.168.1.123"}, "rewrite")
+	require.Len(t, lookupHTTPS(question), 1)
+}
+
+// testResolver start DNS server with one answer record for all questions
+func testResolver(t *testing.T, record string) {
+	rr, err := dns.NewRR(record)
+	require.Nil(t, err)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(wr dns.ResponseWriter, msg *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(msg)
+		m.Answer = []dns.RR{dns.Copy(rr)}
+		_ = wr.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	resolverDial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return net.Dial("udp", conn.LocalAddr().String())
+	}
+	t.Cleanup(func() { resolverDial = nil })
+}
+
+func TestRewriteHTTPS(t *testing.T) {
+	rr, err := dns.NewRR(`example.com. 300 IN HTTPS 1 . mandatory=alpn,ech alpn="h3" no-default-alpn ech="AEX+DQBBAQAgACCA" ipv4hint="1.2.3.4" ipv6hint="2001:db8::1"`)
+	require.Nil(t, err)
+
+	rewriteHTTPS([]dns.RR{rr}, []net.IP{net.ParseIP("192.168.1.123")})
+	require.Equal(t, "example.com.\t300\tIN\tHTTPS\t1 . ipv4hint=\"192.168.1.123\"", rr.String())
+
+	// AliasMode record is not changed
+	rr, err = dns.NewRR(`example.com. 300 IN HTTPS 0 cdn.example.net.`)
+	require.Nil(t, err)
+
+	rewriteHTTPS([]dns.RR{rr}, []net.IP{net.ParseIP("192.168.1.123")})
+	require.Equal(t, "example.com.\t300\tIN\tHTTPS\t0 cdn.example.net.", rr.String())
+}
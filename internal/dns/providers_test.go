@@ -6,13 +6,14 @@ import (
 	"net/url"
 	"testing"
 
+	"github.com/AlexxIT/pnproxy/internal/upstream"
 	"github.com/stretchr/testify/require"
 )
 
 func TestProviders(t *testing.T) {
-	test := func(f func(params url.Values) dialFunc, provider string) {
+	test := func(f func(params url.Values, dialer upstream.Dialer) dialFunc, provider string) {
 		params := map[string][]string{"provider": {provider}}
-		resolver := &net.Resolver{PreferGo: true, Dial: f(params)}
+		resolver := &net.Resolver{PreferGo: true, Dial: f(params, &net.Dialer{})}
 		addrs, err := resolver.LookupHost(context.Background(), "dns.google")
 		require.Nil(t, err)
 		require.Len(t, addrs, 4)
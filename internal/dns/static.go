@@ -1,28 +1,51 @@
 package dns
 
 import (
+	"errors"
 	"net"
 	"strings"
 )
 
-var static = map[string][]net.IP{}
+// staticRecord - addresses and HTTPS records mode (drop or rewrite) for domain
+type staticRecord struct {
+	ips   []net.IP
+	https string
+}
+
+var static = map[string]*staticRecord{}
 
-func addStaticIP(name string, addrs []string) {
+func addStaticIP(name string, addrs []string, https string) {
 	var ips []net.IP
 	for _, addr := range addrs {
 		ips = append(ips, net.ParseIP(addr))
 	}
 	// use suffix point, because all DNS queries has it
 	// use prefix point, because support subdomains by default
-	static["."+name+"."] = ips
+	static["."+name+"."] = &staticRecord{ips: ips, https: https}
+}
+
+func checkStaticIP(addrs []string) error {
+	for _, addr := range addrs {
+		if net.ParseIP(addr) == nil {
+			return errors.New("wrong address: " + addr)
+		}
+	}
+	return nil
 }
 
 func lookupStaticIP(name string) ([]net.IP, error) {
+	if record := lookupStatic(name); record != nil {
+		return record.ips, nil
+	}
+	return nil, nil
+}
+
+func lookupStatic(name string) *staticRecord {
 	name = "." + name
-	for suffix, items := range static {
+	for suffix, record := range static {
 		if strings.HasSuffix(name, suffix) {
-			return items, nil
+			return record
 		}
 	}
-	return nil, nil
+	return nil
 }
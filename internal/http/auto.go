@@ -0,0 +1,98 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+var autoParams = []app.Param{
+	{Name: "via", Required: true}, // names from upstreams for fallback
+	{Name: "interface"},
+	{Name: "ttl"},
+}
+
+const (
+	autoTTL     = 24 * time.Hour
+	autoTimeout = 5 * time.Second
+)
+
+// autoMemory - domains that work only through proxy
+var autoMemory *app.Memory[string]
+
+func handleAuto(params url.Values) (http.HandlerFunc, error) {
+	direct, err := upstream.Direct(url.Values{"interface": params["interface"]})
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := upstream.Proxy(url.Values{"via": params["via"]})
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := autoTTL
+	if s := params.Get("ttl"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+			return nil, errors.New("wrong value for param ttl: " + s)
+		}
+	}
+
+	if autoMemory == nil {
+		autoMemory = app.NewMemory[string]("http.auto")
+	}
+
+	transport := &autoTransport{
+		direct: rawTransport(direct),
+		proxy:  proxyTransport(proxy),
+		ttl:    ttl,
+	}
+	transport.direct.ResponseHeaderTimeout = autoTimeout
+
+	return handleTransport(transport), nil
+}
+
+// autoTransport send request directly and retry it through proxy on error
+type autoTransport struct {
+	direct *http.Transport
+	proxy  *http.Transport
+	ttl    time.Duration
+}
+
+func (t *autoTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if v, _ := autoMemory.Get(host); v == "proxy" {
+		return t.proxy.RoundTrip(r)
+	}
+
+	res, err := t.direct.RoundTrip(r)
+	if err == nil || r.Context().Err() != nil {
+		return res, err
+	}
+
+	// request with body can't be sent second time
+	if r.Body != nil && r.Body != http.NoBody {
+		return nil, err
+	}
+
+	res, proxyErr := t.proxy.RoundTrip(r)
+	if proxyErr != nil {
+		return nil, proxyErr
+	}
+
+	// remember host only if proxy works
+	log.Debug().Err(err).Msgf("[http] auto use proxy host=%s", host)
+	autoMemory.Set(host, "proxy", t.ttl)
+
+	return res, nil
+}
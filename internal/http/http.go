@@ -1,39 +1,49 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/clients"
 	"github.com/AlexxIT/pnproxy/internal/hosts"
+	"github.com/AlexxIT/pnproxy/internal/proxyproto"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
 	"github.com/rs/zerolog/log"
 )
 
 func Init() {
 	var cfg struct {
 		HTTP struct {
-			Listen string `yaml:"listen"`
-			Rules  []struct {
-				Name   string `yaml:"name"`
-				Action string `yaml:"action"`
+			Listen        string `yaml:"listen"`
+			ProxyProtocol string `yaml:"proxy_protocol"`
+			Rules         []struct {
+				Name   string     `yaml:"name"`
+				Action app.Action `yaml:"action"`
 			}
 			Default struct {
-				Action string `yaml:"action"`
+				Action app.Action `yaml:"action"`
 			} `yaml:"default"`
 		} `yaml:"http"`
 	}
 
-	cfg.HTTP.Default.Action = "raw_pass"
+	initActions()
+
+	cfg.HTTP.Default.Action = app.Action{Name: "raw_pass"}
 
 	app.LoadConfig(&cfg)
 
 	for _, rule := range cfg.HTTP.Rules {
-		handler := parseAction(rule.Action)
-		if handler == nil {
-			log.Warn().Msgf("[http] wrong action: %s", rule.Action)
+		handler, err := actions.New(&rule.Action)
+		if err != nil {
+			app.ConfigError(rule.Action.Errorf("[http] %w", err))
 			continue
 		}
 
@@ -42,14 +52,43 @@ func Init() {
 		}
 	}
 
-	defaultHandler = parseAction(cfg.HTTP.Default.Action)
+	if cfg.HTTP.Default.Action.Name != "" {
+		var err error
+		if defaultHandler, err = actions.New(&cfg.HTTP.Default.Action); err != nil {
+			app.ConfigError(cfg.HTTP.Default.Action.Errorf("[http] %w", err))
+		}
+	}
 
 	if cfg.HTTP.Listen != "" {
-		go serve(cfg.HTTP.Listen)
+		trusted, err := clients.Get(cfg.HTTP.ProxyProtocol)
+		if err != nil {
+			app.ConfigError(fmt.Errorf("[http] wrong proxy_protocol: %w", err))
+		}
+
+		srv := &http.Server{
+			Addr:    cfg.HTTP.Listen,
+			Handler: http.HandlerFunc(Handle),
+		}
+		app.OnStart("http", func() error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			go serve(srv, proxyproto.Listen(ln, trusted))
+			return nil
+		})
+		app.OnStop("http", func(ctx context.Context) error {
+			return app.ShutdownServer(ctx, srv)
+		})
 	}
 }
 
 func Handle(w http.ResponseWriter, r *http.Request) {
+	if acmeHandler != nil && strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+		acmeHandler.ServeHTTP(w, r)
+		return
+	}
+
 	domain := r.Host
 	if i := strings.IndexByte(r.Host, ':'); i > 0 {
 		domain = domain[:i]
@@ -69,6 +108,14 @@ func Handle(w http.ResponseWriter, r *http.Request) {
 var handlers = map[string]http.HandlerFunc{}
 var defaultHandler http.HandlerFunc
 
+// acmeHandler - ACME HTTP-01 challenges from TLS module
+var acmeHandler http.Handler
+
+// HandleACME set handler for ACME HTTP-01 challenges, it is checked before rules
+func HandleACME(handler http.Handler) {
+	acmeHandler = handler
+}
+
 func findHandler(domain string) http.HandlerFunc {
 	domain = "." + domain
 	for k, handler := range handlers {
@@ -79,37 +126,32 @@ func findHandler(domain string) http.HandlerFunc {
 	return defaultHandler
 }
 
-func serve(address string) {
-	log.Info().Msgf("[http] listen=%s", address)
-	srv := &http.Server{
-		Addr:    address,
-		Handler: http.HandlerFunc(Handle),
-	}
-	if err := srv.ListenAndServe(); err != nil {
+func serve(srv *http.Server, ln net.Listener) {
+	log.Info().Msgf("[http] listen=%s", srv.Addr)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Error().Err(err).Caller().Send()
+		app.ModuleStatus("http", err)
 	}
 }
 
-func parseAction(raw string) http.HandlerFunc {
-	if raw != "" {
-		action, params := app.ParseAction(raw)
-		switch action {
-		case "redirect":
-			return handleRedirect(params)
-		case "raw_pass":
-			return handleRaw(params)
-		case "proxy_pass":
-			return handleProxy(params)
-		default:
-		}
-	}
-	return nil
+var actions = app.NewRegistry[http.HandlerFunc]()
+
+func initActions() {
+	actions.Register("redirect", []app.Param{
+		{Name: "scheme"}, {Name: "code"},
+	}, handleRedirect)
+	app.Extend(actions, upstream.Actions, "raw_pass", nil, handleRaw)
+	app.Extend(actions, upstream.Actions, "proxy_pass", nil, handleProxy)
+	actions.Register("auto", autoParams, handleAuto)
 }
 
-func handleRedirect(params url.Values) http.HandlerFunc {
+func handleRedirect(params url.Values) (http.HandlerFunc, error) {
 	code := http.StatusTemporaryRedirect
 	if params.Has("code") {
-		code, _ = strconv.Atoi(params.Get("code"))
+		var err error
+		if code, err = strconv.Atoi(params.Get("code")); err != nil || code < 300 || code > 399 {
+			return nil, errors.New("wrong code: " + params.Get("code"))
+		}
 	}
 	scheme := params.Get("scheme")
 
@@ -119,12 +161,13 @@ func handleRedirect(params url.Values) http.HandlerFunc {
 		}
 		w.Header().Add("Location", r.URL.String())
 		w.WriteHeader(code)
-	}
+	}, nil
 }
 
 func handleTransport(transport http.RoundTripper) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.Header.Set("Host", r.Host)
+		r = r.WithContext(upstream.WithClient(r.Context(), r.RemoteAddr))
 
 		res, err := transport.RoundTrip(r)
 		if err != nil {
@@ -145,34 +188,31 @@ func handleTransport(transport http.RoundTripper) http.HandlerFunc {
 	}
 }
 
-func handleRaw(params url.Values) http.HandlerFunc {
-	return handleTransport(http.DefaultTransport)
+func handleRaw(_ url.Values, dialer upstream.Dialer) (http.HandlerFunc, error) {
+	return handleTransport(rawTransport(dialer)), nil
 }
 
-func handleProxy(params url.Values) http.HandlerFunc {
-	if !params.Has("host") {
-		return nil
-	}
+func handleProxy(_ url.Values, dialer upstream.Dialer) (http.HandlerFunc, error) {
+	return handleTransport(proxyTransport(dialer)), nil
+}
 
-	proxyURL := &url.URL{Host: params.Get("host")}
-	if params.Has("type") {
-		proxyURL.Scheme = params.Get("type")
-	} else {
-		proxyURL.Scheme = "http"
-	}
-	if params.Has("port") {
-		proxyURL.Host += ":" + params.Get("port")
-	}
-	if params.Has("username") {
-		if params.Has("password") {
-			proxyURL.User = url.UserPassword(params.Get("username"), params.Get("password"))
-		} else {
-			proxyURL.User = url.User(params.Get("username"))
-		}
-	}
+func rawTransport(dialer upstream.Dialer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
 
+func proxyTransport(dialer upstream.Dialer) *http.Transport {
 	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.Proxy = http.ProxyURL(proxyURL)
 
-	return handleTransport(transport)
+	// send plain HTTP requests to HTTP proxy, because many proxies allow CONNECT only for 443 port
+	if proxyURL, forward, ok := upstream.HTTPProxy(dialer); ok {
+		transport.Proxy = http.ProxyURL(proxyURL)
+		transport.DialContext = forward.DialContext
+	} else {
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+	}
+
+	return transport
 }
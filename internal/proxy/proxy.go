@@ -1,10 +1,16 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/clients"
 	ihttp "github.com/AlexxIT/pnproxy/internal/http"
+	"github.com/AlexxIT/pnproxy/internal/proxyproto"
 	"github.com/AlexxIT/pnproxy/internal/tls"
 	"github.com/rs/zerolog/log"
 )
@@ -12,25 +18,45 @@ import (
 func Init() {
 	var cfg struct {
 		Proxy struct {
-			Listen string `yaml:"listen"`
+			Listen        string `yaml:"listen"`
+			ProxyProtocol string `yaml:"proxy_protocol"`
 		} `yaml:"proxy"`
 	}
 
 	app.LoadConfig(&cfg)
 
 	if cfg.Proxy.Listen != "" {
-		go serve(cfg.Proxy.Listen)
+		trusted, err := clients.Get(cfg.Proxy.ProxyProtocol)
+		if err != nil {
+			app.ConfigError(fmt.Errorf("[proxy] wrong proxy_protocol: %w", err))
+		}
+
+		srv := &http.Server{
+			Addr:    cfg.Proxy.Listen,
+			Handler: http.HandlerFunc(Handle),
+		}
+		app.OnStart("proxy", func() error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			go serve(srv, proxyproto.Listen(ln, trusted))
+			return nil
+		})
+		app.OnStop("proxy", func(ctx context.Context) error {
+			err := app.ShutdownServer(ctx, srv)
+			// server shutdown doesn't wait for hijacked CONNECT tunnels, they are TLS module connections
+			tls.Drain(ctx)
+			return err
+		})
 	}
 }
 
-func serve(address string) {
-	log.Info().Msgf("[proxy] listen=%s", address)
-	srv := &http.Server{
-		Addr:    address,
-		Handler: http.HandlerFunc(Handle),
-	}
-	if err := srv.ListenAndServe(); err != nil {
+func serve(srv *http.Server, ln net.Listener) {
+	log.Info().Msgf("[proxy] listen=%s", srv.Addr)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Error().Err(err).Caller().Send()
+		app.ModuleStatus("proxy", err)
 	}
 }
 
@@ -0,0 +1,233 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/clients"
+)
+
+// PROXY protocol, https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const headerTimeout = 5 * time.Second
+
+var errHeader = errors.New("proxyproto: wrong header")
+
+// Listen wrap listener, connections from trusted networks should start with PROXY protocol header
+func Listen(ln net.Listener, trusted []*net.IPNet) net.Listener {
+	if trusted == nil {
+		return ln
+	}
+	return &listener{Listener: ln, trusted: trusted}
+}
+
+type listener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !clients.Contains(l.trusted, conn.RemoteAddr().String()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// Conn - connection with addresses from PROXY protocol header, header is read on first use
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.local, c.err = ReadHeader(c.r)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.init(); c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.init(); c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if c.init(); c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadHeader read v1 or v2 header and return addresses, nil addresses for LOCAL and UNKNOWN
+func ReadHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	b, err := r.Peek(len(signature))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(b, signature) {
+		return readV2(r)
+	}
+
+	if bytes.HasPrefix(b, []byte("PROXY ")) {
+		return readV1(r)
+	}
+
+	return nil, nil, errHeader
+}
+
+func readV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	// max header size is 107 bytes
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 {
+		return nil, nil, errHeader
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, nil, errHeader
+		}
+	default:
+		return nil, nil, errHeader
+	}
+
+	if remote, err = parseAddr(fields[2], fields[4]); err != nil {
+		return nil, nil, err
+	}
+	if local, err = parseAddr(fields[3], fields[5]); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+func parseAddr(ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, errHeader
+	}
+	var err error
+	if addr.Port, err = strconv.Atoi(port); err != nil || addr.Port > 0xFFFF {
+		return nil, errHeader
+	}
+	return addr, nil
+}
+
+func readV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	b := make([]byte, 16)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, nil, err
+	}
+
+	if b[12]>>4 != 2 {
+		return nil, nil, errHeader
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(b[14:]))
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL command - health checks from proxy itself
+	if b[12]&0x0f == 0 {
+		return nil, nil, nil
+	}
+
+	var size int
+	switch b[13] >> 4 {
+	case 1: // IPv4
+		size = 4
+	case 2: // IPv6
+		size = 16
+	default:
+		return nil, nil, nil // unix sockets and unspecified
+	}
+
+	if len(data) < 2*size+4 {
+		return nil, nil, errHeader
+	}
+
+	remote = &net.TCPAddr{IP: net.IP(data[:size]), Port: int(binary.BigEndian.Uint16(data[2*size:]))}
+	local = &net.TCPAddr{IP: net.IP(data[size : 2*size]), Port: int(binary.BigEndian.Uint16(data[2*size+2:]))}
+	return
+}
+
+// Header return v1 or v2 header for connection from remote to local address
+func Header(version string, remote, local net.Addr) []byte {
+	src, ok1 := remote.(*net.TCPAddr)
+	dst, ok2 := local.(*net.TCPAddr)
+
+	// both addresses should be same family
+	family := 0
+	if ok1 && ok2 {
+		if src.IP.To4() != nil && dst.IP.To4() != nil {
+			family = 4
+		} else if src.IP.To4() == nil && dst.IP.To4() == nil {
+			family = 6
+		}
+	}
+
+	if version == "v1" {
+		if family == 0 {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		return []byte(fmt.Sprintf("PROXY TCP%d %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port))
+	}
+
+	b := append([]byte{}, signature...)
+
+	switch family {
+	case 4:
+		b = append(b, 0x21, 0x11, 0, 12)
+		b = append(b, src.IP.To4()...)
+		b = append(b, dst.IP.To4()...)
+	case 6:
+		b = append(b, 0x21, 0x21, 0, 36)
+		b = append(b, src.IP.To16()...)
+		b = append(b, dst.IP.To16()...)
+	default:
+		return append(b, 0x20, 0x00, 0, 0) // LOCAL
+	}
+
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(b, uint16(src.Port)), uint16(dst.Port))
+}
@@ -0,0 +1,61 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	test := func(version string, remote, local string) {
+		src, _ := net.ResolveTCPAddr("tcp", remote)
+		dst, _ := net.ResolveTCPAddr("tcp", local)
+
+		b := Header(version, src, dst)
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(b), bytes.NewReader([]byte("data"))))
+
+		addr1, addr2, err := ReadHeader(r)
+		require.Nil(t, err)
+		require.Equal(t, remote, addr1.String())
+		require.Equal(t, local, addr2.String())
+
+		data, _ := io.ReadAll(r)
+		require.Equal(t, "data", string(data))
+	}
+
+	test("v1", "1.2.3.4:50000", "192.168.1.1:443")
+	test("v2", "1.2.3.4:50000", "192.168.1.1:443")
+	test("v1", "[2001:db8::1]:50000", "[2001:db8::2]:443")
+	test("v2", "[2001:db8::1]:50000", "[2001:db8::2]:443")
+
+	require.Equal(t, "PROXY UNKNOWN\r\n", string(Header("v1", &net.UDPAddr{}, &net.TCPAddr{})))
+
+	_, _, err := ReadHeader(bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n"))))
+	require.NotNil(t, err)
+}
+
+func TestListen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	_, trusted, _ := net.ParseCIDR("127.0.0.0/8")
+	ln = Listen(ln, []*net.IPNet{trusted})
+
+	go func() {
+		conn, _ := net.Dial("tcp", ln.Addr().String())
+		_, _ = conn.Write([]byte("PROXY TCP4 1.2.3.4 192.168.1.1 50000 443\r\nhello"))
+		_ = conn.Close()
+	}()
+
+	conn, err := ln.Accept()
+	require.Nil(t, err)
+	require.Equal(t, "1.2.3.4:50000", conn.RemoteAddr().String())
+
+	data, _ := io.ReadAll(conn)
+	require.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,146 @@
+package tls
+
+import (
+	"context"
+	gotls "crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+var autoParams = []app.Param{
+	{Name: "via", Required: true}, // names from upstreams for fallback
+	{Name: "direct", Values: []string{"raw_pass", "split_pass"}},
+	{Name: "strategy"}, // split_pass strategies for direct connections
+	{Name: "interface"},
+	{Name: "ttl"},
+	{Name: "check", Values: []string{"cert"}},
+}
+
+const (
+	autoTTL     = 24 * time.Hour
+	autoTimeout = 5 * time.Second
+)
+
+// autoMemory - domains that work only through proxy or direct domains with checked certificate
+var autoMemory *app.Memory[string]
+
+func handleAuto(params url.Values) (handlerFunc, error) {
+	direct, err := upstream.Direct(url.Values{"interface": params["interface"]})
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := upstream.Proxy(url.Values{"via": params["via"]})
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := autoTTL
+	if s := params.Get("ttl"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+			return nil, errors.New("wrong value for param ttl: " + s)
+		}
+	}
+
+	// split_pass uses the same strategies ladder and learned levels as split_pass action
+	passDirect := func(src net.Conn, host string, hello []byte) error {
+		return passHandshake(direct, src, host, hello)
+	}
+	if params.Get("direct") == "split_pass" {
+		split, err := newSplit(url.Values{"interface": params["interface"], "strategy": params["strategy"]})
+		if err != nil {
+			return nil, err
+		}
+		passDirect = split
+	}
+
+	if autoMemory == nil {
+		autoMemory = app.NewMemory[string]("tls.auto")
+	}
+
+	checkCert := params.Get("check") == "cert"
+
+	return func(src net.Conn, host string, hello []byte) {
+		v, _ := autoMemory.Get(host)
+		if v != "proxy" {
+			if checkCert && v == "" {
+				autoMemory.Set(host, "direct", ttl)
+				go checkCertificate(direct, host, ttl)
+			}
+
+			err := passDirect(src, host, hello)
+			if err == nil {
+				return
+			}
+
+			log.Debug().Err(err).Msgf("[tls] auto retry with proxy host=%s", host)
+		}
+
+		if err := passHandshake(proxy, src, host, hello); err != nil {
+			log.Warn().Err(err).Msgf("[tls] auto proxy fail host=%s", host)
+			return
+		}
+
+		// remember host only if proxy works
+		if v != "proxy" {
+			log.Debug().Msgf("[tls] auto use proxy host=%s", host)
+			autoMemory.Set(host, "proxy", ttl)
+		}
+	}, nil
+}
+
+// passHandshake send ClientHello and pass connection if server answers with ServerHello
+func passHandshake(dialer upstream.Dialer, src net.Conn, host string, hello []byte) error {
+	write := func(dst net.Conn, hello []byte) error {
+		_, err := dst.Write(hello)
+		return err
+	}
+
+	dst, b, err := handshake(clientContext(src), dialer, host+":443", hello, write, autoTimeout)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if !isServerHello(b) {
+		return errors.New("tls: no ServerHello")
+	}
+
+	if _, err = src.Write(b); err == nil {
+		pipe(src, dst)
+	}
+	return nil
+}
+
+// checkCertificate connect to host directly with own TLS client and switch host to proxy
+// if certificate is not valid. Middlebox can answer with own certificate instead of real server.
+// Certificate in the client connection can't be checked, because it is encrypted in TLS 1.3.
+func checkCertificate(dialer upstream.Dialer, host string, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), autoTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", host+":443")
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = gotls.Client(conn, &gotls.Config{ServerName: host}).HandshakeContext(ctx)
+
+	var certErr *gotls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		log.Debug().Err(err).Msgf("[tls] auto use proxy host=%s", host)
+		autoMemory.Set(host, "proxy", ttl)
+	}
+}
+
+// isServerHello check that response starts with handshake record with ServerHello message
+func isServerHello(b []byte) bool {
+	return len(b) >= 6 && b[0] == 0x16 && b[5] == 0x02
+}
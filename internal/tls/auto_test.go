@@ -0,0 +1,52 @@
+package tls
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCertificate(t *testing.T) {
+	// server with self-signed certificate like from middlebox
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+
+	dialer := testDialer(srv.Listener.Addr().String())
+
+	autoMemory = app.NewMemory[string]("test.auto")
+
+	checkCertificate(dialer, "example.com", time.Hour)
+
+	v, _ := autoMemory.Get("example.com")
+	require.Equal(t, "proxy", v)
+}
+
+func TestAutoProxyFail(t *testing.T) {
+	autoMemory = app.NewMemory[string]("test.auto")
+
+	handler, err := handleAuto(url.Values{"via": {"direct"}})
+	require.Nil(t, err)
+
+	src, dst := net.Pipe()
+	defer dst.Close()
+
+	// nothing listens on 443 port, so direct and proxy connections fail
+	handler(src, "127.0.0.1", []byte{0x16, 0x03, 0x01})
+
+	_, ok := autoMemory.Get("127.0.0.1")
+	require.False(t, ok)
+}
+
+// testDialer - dial all addresses to one address
+type testDialer string
+
+func (d testDialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, string(d))
+}
@@ -0,0 +1,113 @@
+package tls
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// active connections for graceful shutdown and API, new connections are not accepted while draining
+var (
+	conns    = map[net.Conn]*Conn{}
+	connsMu  sync.Mutex
+	connsWG  sync.WaitGroup
+	draining bool
+
+	drainTimeout time.Duration
+)
+
+// Conn - active connection info for API
+type Conn struct {
+	RemoteAddr string       `json:"remote_addr"`
+	Since      time.Time    `json:"since"`
+	Hello      *ClientHello `json:"hello,omitempty"`
+	JA3        string       `json:"ja3,omitempty"`
+	JA4        string       `json:"ja4,omitempty"`
+}
+
+func addConn(conn net.Conn) bool {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+
+	if draining {
+		return false
+	}
+
+	conns[conn] = &Conn{RemoteAddr: conn.RemoteAddr().String(), Since: time.Now()}
+	connsWG.Add(1)
+	return true
+}
+
+// setConnHello save ClientHello to connection info and return copy of info
+func setConnHello(conn net.Conn, hello *ClientHello) *Conn {
+	info := Conn{
+		RemoteAddr: conn.RemoteAddr().String(),
+		Hello:      hello,
+		JA3:        hello.JA3(),
+		JA4:        hello.JA4(),
+	}
+
+	connsMu.Lock()
+	if c := conns[conn]; c != nil {
+		info.Since = c.Since
+		*c = info
+	}
+	connsMu.Unlock()
+
+	return &info
+}
+
+// Conns return copy of active connections info
+func Conns() []Conn {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+
+	res := make([]Conn, 0, len(conns))
+	for _, c := range conns {
+		res = append(res, *c)
+	}
+	return res
+}
+
+func delConn(conn net.Conn) {
+	connsMu.Lock()
+	delete(conns, conn)
+	connsWG.Done()
+	connsMu.Unlock()
+}
+
+// Drain stop accepting connections from TLS listener and proxy module tunnels,
+// wait for active connections and force close them after drain timeout
+func Drain(ctx context.Context) {
+	connsMu.Lock()
+	draining = true
+	connsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		connsWG.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	connsMu.Lock()
+	log.Warn().Msgf("[tls] force close connections=%d", len(conns))
+	for conn := range conns {
+		_ = conn.Close()
+	}
+	connsMu.Unlock()
+
+	<-done
+}
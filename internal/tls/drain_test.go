@@ -0,0 +1,34 @@
+package tls
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	defer conn2.Close()
+
+	require.True(t, addConn(conn1))
+
+	// active connection is force closed
+	var err error
+	go func() {
+		_, err = conn1.Read(make([]byte, 1))
+		delConn(conn1)
+	}()
+
+	drainTimeout = 10 * time.Millisecond
+	Drain(context.Background())
+	defer func() { draining = false }()
+
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+
+	// new connections are not accepted while draining
+	require.False(t, addConn(conn2))
+}
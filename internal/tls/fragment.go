@@ -0,0 +1,28 @@
+package tls
+
+import (
+	"net"
+	"net/url"
+)
+
+// withFragment send ClientHello in several TLS records, if action has fragment param
+func withFragment(handler handlerFunc, params url.Values) (handlerFunc, error) {
+	if !params.Has("fragment") {
+		return handler, nil
+	}
+
+	// empty sizes - split in the middle of server name
+	var sizes []int
+	if s := params.Get("fragment"); s != "sni" {
+		var err error
+		if sizes, err = parseSizes(s); err != nil {
+			return nil, err
+		}
+	}
+
+	strategy := &splitStrategy{records: append([]int{}, sizes...)}
+
+	return func(src net.Conn, host string, hello []byte) {
+		handler(src, host, strategy.apply(hello, host))
+	}, nil
+}
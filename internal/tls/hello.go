@@ -0,0 +1,311 @@
+package tls
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ClientHello - parsed TLS ClientHello message
+type ClientHello struct {
+	Version             uint16   `json:"version"` // legacy version from message
+	ServerName          string   `json:"server_name,omitempty"`
+	ALPN                []string `json:"alpn,omitempty"`
+	Versions            []uint16 `json:"versions,omitempty"` // supported_versions extension
+	CipherSuites        []uint16 `json:"cipher_suites"`
+	Extensions          []uint16 `json:"extensions"` // types in original order
+	Groups              []uint16 `json:"groups,omitempty"`
+	KeyShares           []uint16 `json:"key_shares,omitempty"` // groups of key shares
+	SignatureAlgorithms []uint16 `json:"signature_algorithms,omitempty"`
+	PointFormats        []uint8  `json:"point_formats,omitempty"`
+	ECH                 bool     `json:"ech"`            // encrypted_client_hello extension with public name
+	QUIC                bool     `json:"quic,omitempty"` // hello from QUIC Initial packet
+}
+
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extPointFormats        = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+	extKeyShare            = 0x0033
+	extECH                 = 0xfe0d
+)
+
+var errClientHello = errors.New("tls: wrong client hello")
+
+// ParseClientHello parse ClientHello from one or many TLS records
+func ParseClientHello(hello []byte) (*ClientHello, error) {
+	payload := recordsPayload(hello)
+
+	// https://datatracker.ietf.org/doc/html/rfc8446#section-4.1.2
+	r := &reader{b: payload}
+	if r.u8() != 0x01 {
+		return nil, errClientHello
+	}
+
+	r = &reader{b: r.bytes(int(r.u24()))}
+
+	h := &ClientHello{Version: r.u16()}
+	r.bytes(32) // random
+	r.vec8()    // session ID
+
+	for s := (&reader{b: r.vec16()}); s.len() > 0; {
+		h.CipherSuites = append(h.CipherSuites, s.u16())
+	}
+
+	r.vec8() // compression methods
+
+	if r.err {
+		return nil, errClientHello
+	}
+
+	// extensions are optional
+	if r.len() == 0 {
+		return h, nil
+	}
+
+	var ech bool
+	for exts := (&reader{b: r.vec16()}); exts.len() > 0; {
+		extType := exts.u16()
+		ext := &reader{b: exts.vec16()}
+		if exts.err {
+			return nil, errClientHello
+		}
+
+		h.Extensions = append(h.Extensions, extType)
+
+		switch extType {
+		case extServerName:
+			for list := (&reader{b: ext.vec16()}); list.len() > 0; {
+				nameType, name := list.u8(), list.vec16()
+				if nameType == 0 && !list.err {
+					h.ServerName = string(name)
+				}
+			}
+		case extALPN:
+			for list := (&reader{b: ext.vec16()}); list.len() > 0; {
+				if proto := list.vec8(); !list.err {
+					h.ALPN = append(h.ALPN, string(proto))
+				}
+			}
+		case extSupportedVersions:
+			for list := (&reader{b: ext.vec8()}); list.len() > 0; {
+				h.Versions = append(h.Versions, list.u16())
+			}
+		case extSupportedGroups:
+			for list := (&reader{b: ext.vec16()}); list.len() > 0; {
+				h.Groups = append(h.Groups, list.u16())
+			}
+		case extPointFormats:
+			h.PointFormats = append(h.PointFormats, ext.vec8()...)
+		case extSignatureAlgorithms:
+			for list := (&reader{b: ext.vec16()}); list.len() > 0; {
+				h.SignatureAlgorithms = append(h.SignatureAlgorithms, list.u16())
+			}
+		case extKeyShare:
+			for list := (&reader{b: ext.vec16()}); list.len() > 0; {
+				group := list.u16()
+				list.vec16() // key exchange
+				if !list.err {
+					h.KeyShares = append(h.KeyShares, group)
+				}
+			}
+		case extECH:
+			ech = true
+		}
+	}
+
+	// GREASE ECH extension looks like real one, so only public names of ECH providers are checked
+	h.ECH = ech && isECHPublic(h.ServerName)
+
+	return h, nil
+}
+
+// JA3 return MD5 hash of JA3 fingerprint string, GREASE values are skipped
+func (h *ClientHello) JA3() string {
+	s := strconv.Itoa(int(h.Version)) + "," +
+		joinInts(h.CipherSuites, "-") + "," +
+		joinInts(h.Extensions, "-") + "," +
+		joinInts(h.Groups, "-") + "," +
+		joinInts(h.PointFormats, "-")
+
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// JA4 return JA4 fingerprint, https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+func (h *ClientHello) JA4() string {
+	version := h.Version
+	for _, v := range h.Versions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+
+	sni := "i"
+	if h.ServerName != "" {
+		sni = "d"
+	}
+
+	alpn := "00"
+	if len(h.ALPN) > 0 && h.ALPN[0] != "" {
+		s := h.ALPN[0]
+		if !isAlnum(s[0]) || !isAlnum(s[len(s)-1]) {
+			s = hex.EncodeToString([]byte(s))
+		}
+		alpn = s[:1] + s[len(s)-1:]
+	}
+
+	ciphers := skipGREASE(h.CipherSuites)
+	extensions := skipGREASE(h.Extensions)
+
+	protocol := "t"
+	if h.QUIC {
+		protocol = "q"
+	}
+
+	a := fmt.Sprintf("%s%s%s%02d%02d%s", protocol, versionName(version), sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	slices.Sort(ciphers)
+	b := hash12(joinHex(ciphers))
+
+	extensions = slices.DeleteFunc(extensions, func(v uint16) bool {
+		return v == extServerName || v == extALPN
+	})
+	slices.Sort(extensions)
+
+	c := joinHex(extensions)
+	if algorithms := skipGREASE(h.SignatureAlgorithms); len(algorithms) > 0 {
+		c += "_" + joinHex(algorithms)
+	}
+	if len(extensions) == 0 {
+		c = ""
+	}
+
+	return a + "_" + b + "_" + hash12(c)
+}
+
+func versionName(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// isGREASE check reserved values, https://datatracker.ietf.org/doc/html/rfc8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func skipGREASE(values []uint16) []uint16 {
+	res := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func joinInts[T uint8 | uint16](values []T, sep string) string {
+	var sb strings.Builder
+	for _, v := range values {
+		if isGREASE(uint16(v)) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(strconv.Itoa(int(v)))
+	}
+	return sb.String()
+}
+
+func joinHex(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+func hash12(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+// reader - bounds safe reader, all reads after error return zero values
+type reader struct {
+	b   []byte
+	err bool
+}
+
+func (r *reader) len() int {
+	if r.err {
+		return 0
+	}
+	return len(r.b)
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err || n < 0 || n > len(r.b) {
+		r.err = true
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) u24() uint32 {
+	if b := r.bytes(3); b != nil {
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	}
+	return 0
+}
+
+func (r *reader) vec8() []byte {
+	return r.bytes(int(r.u8()))
+}
+
+func (r *reader) vec16() []byte {
+	return r.bytes(int(r.u16()))
+}
@@ -0,0 +1,212 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientHello(t *testing.T) {
+	hello, _ := hex.DecodeString(testHello)
+
+	h, err := ParseClientHello(hello)
+	require.Nil(t, err)
+	require.Equal(t, "habr.com", h.ServerName)
+	require.Equal(t, []string{"http/1.1"}, h.ALPN)
+	require.Equal(t, []uint16{0x0a0a, 0x0304, 0x0303, 0x0302, 0x0301}, h.Versions)
+	require.Equal(t, []uint16{0xbaba, 0x001d}, h.KeyShares)
+	require.Len(t, h.CipherSuites, 21)
+	require.Len(t, h.Extensions, 16)
+	require.False(t, h.ECH)
+
+	require.Equal(t, "773906b0efdefa24a7f2b8eb6985bf37", h.JA3())
+	require.Equal(t, "t13d2014h1_a09f3c656075_14788d8d241b", h.JA4())
+
+	// same hello in several records
+	h2, err := ParseClientHello(buildRecords(hello[1:3], recordsPayload(hello), []int{10, 100}))
+	require.Nil(t, err)
+	require.Equal(t, h, h2)
+
+	_, err = ParseClientHello(hello[:100])
+	require.NotNil(t, err)
+}
+
+func TestJA4(t *testing.T) {
+	// Chrome fingerprint from JA4 docs, with GREASE values in all lists
+	hello := testClientHello([]uint16{
+		0x3a3a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+		0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+	}, []testExtension{
+		{0x0a0a, nil},
+		{extServerName, testVec16(append([]byte{0, 0, 11}, "example.com"...))},
+		{0x0017, nil},
+		{0xff01, []byte{0}},
+		{extSupportedGroups, testVec16([]byte{0x4a, 0x4a, 0x00, 0x1d})},
+		{extPointFormats, []byte{1, 0}},
+		{0x0023, nil},
+		{extALPN, testVec16([]byte{2, 'h', '2'})},
+		{0x0005, []byte{1, 0, 0, 0, 0}},
+		{extSignatureAlgorithms, testVec16([]byte{
+			0x5a, 0x5a, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03,
+			0x08, 0x05, 0x05, 0x01, 0x08, 0x06, 0x06, 0x01,
+		})},
+		{0x0012, nil},
+		{extKeyShare, testVec16([]byte{0x00, 0x1d, 0x00, 0x01, 0x00})},
+		{0x002d, []byte{1, 1}},
+		{extSupportedVersions, []byte{4, 0x6a, 0x6a, 0x03, 0x04}},
+		{0x001b, []byte{2, 0, 2}},
+		{0x4469, nil},
+		{extECH, nil},
+		{0x1a1a, []byte{0}},
+	})
+
+	h, err := ParseClientHello(hello)
+	require.Nil(t, err)
+	require.Equal(t, "t13d1516h2_8daaf6152771_02713d6af862", h.JA4())
+
+	h.QUIC = true
+	require.Equal(t, "q13d1516h2_8daaf6152771_02713d6af862", h.JA4())
+}
+
+func TestGreaseECH(t *testing.T) {
+	hello := func(name string) []byte {
+		return testClientHello([]uint16{0x1301}, []testExtension{
+			{extServerName, testVec16(append([]byte{0, 0, byte(len(name))}, name...))},
+			{extECH, []byte{0, 0, 1, 0, 1, 0x2a, 0, 0, 0, 0}},
+		})
+	}
+
+	// browsers send GREASE ECH to all servers
+	h, err := ParseClientHello(hello("example.com"))
+	require.Nil(t, err)
+	require.Equal(t, "example.com", h.ServerName)
+	require.False(t, h.ECH)
+
+	h, err = ParseClientHello(hello("cloudflare-ech.com"))
+	require.Nil(t, err)
+	require.True(t, h.ECH)
+}
+
+type testExtension struct {
+	typ  uint16
+	data []byte
+}
+
+// testClientHello build ClientHello record with ciphers and extensions
+func testClientHello(ciphers []uint16, extensions []testExtension) []byte {
+	b := []byte{0x03, 0x03}
+	b = append(b, make([]byte, 32)...) // random
+	b = append(b, 0)                   // session ID
+
+	var list []byte
+	for _, v := range ciphers {
+		list = binary.BigEndian.AppendUint16(list, v)
+	}
+	b = append(b, testVec16(list)...)
+	b = append(b, 1, 0) // compression methods
+
+	list = nil
+	for _, ext := range extensions {
+		list = binary.BigEndian.AppendUint16(list, ext.typ)
+		list = append(list, testVec16(ext.data)...)
+	}
+	b = append(b, testVec16(list)...)
+
+	payload := append([]byte{0x01, 0, byte(len(b) >> 8), byte(len(b))}, b...)
+	return buildRecords([]byte{0x03, 0x01}, payload, nil)
+}
+
+func testVec16(b []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+}
+
+func FuzzParseClientHello(f *testing.F) {
+	hello, _ := hex.DecodeString(testHello)
+	f.Add(hello)
+	f.Add(hello[:100])
+	f.Add([]byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x01, 0x00, 0x00, 0x00})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if h, err := ParseClientHello(b); err == nil {
+			_, _ = h.JA3(), h.JA4()
+		}
+		_, _ = readClientHello(bytes.NewReader(b))
+		if frames, err := parseQUICInitial(b); err == nil {
+			_ = cryptoHello(frames)
+		}
+	})
+}
+
+func TestFindHandler(t *testing.T) {
+	hello, _ := hex.DecodeString(testHello)
+	h, err := ParseClientHello(hello)
+	require.Nil(t, err)
+
+	var got string
+	handler := func(name string) handlerFunc {
+		return func(net.Conn, string, []byte) { got = name }
+	}
+
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+
+	rules = []*rule{
+		{domains: []string{".example.com"}, handler: handler("domain")},
+		{alpn: []string{"h2"}, handler: handler("alpn")},
+		{ja4: []string{"t13d2014h1_a09f3c656075_14788d8d241b"}, clients: []*net.IPNet{lan}, handler: handler("ja4")},
+		{domains: []string{".habr.com"}, handler: handler("habr")},
+	}
+	defaultHandler = handler("default")
+	defer func() { rules, defaultHandler = nil, nil }()
+
+	test := func(remote string) string {
+		findHandler(&Conn{RemoteAddr: remote, Hello: h, JA4: h.JA4()}, []string{h.ServerName})(nil, "", nil)
+		return got
+	}
+
+	require.Equal(t, "ja4", test("192.168.1.10:50000"))
+	require.Equal(t, "habr", test("10.0.0.1:50000"))
+
+	h.ServerName = "www.example.com"
+	require.Equal(t, "domain", test("192.168.1.10:50000"))
+
+	h.ServerName = "google.com"
+	require.Equal(t, "default", test("10.0.0.1:50000"))
+
+	require.True(t, Covered("www.habr.com."))
+	require.False(t, Covered("google.com."))
+
+	// ECH policy with several domains matches rule of any domain
+	block, domains, err := parseECH("google.com habr.com")
+	require.Nil(t, err)
+	require.False(t, block)
+
+	h.ECH = true
+	echDomains = domains
+	defer func() { echDomains = nil }()
+
+	match, ok := echMatch(h)
+	require.True(t, ok)
+	findHandler(&Conn{RemoteAddr: "10.0.0.1:50000", Hello: h}, match)(nil, "", nil)
+	require.Equal(t, "habr", got)
+}
+
+func TestParseECH(t *testing.T) {
+	block, domains, err := parseECH("block")
+	require.Nil(t, err)
+	require.True(t, block)
+	require.Nil(t, domains)
+
+	_, domains, err = parseECH("outer")
+	require.Nil(t, err)
+	require.Nil(t, domains)
+
+	// unknown hosts name
+	_, _, err = parseECH("list1")
+	require.NotNil(t, err)
+	_, _, err = parseECH("example.com list1")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,338 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	ihttp "github.com/AlexxIT/pnproxy/internal/http"
+	"github.com/rs/zerolog/log"
+)
+
+// local CA for MITM certificates, loaded or generated on first mitm action
+var (
+	caCertPath string
+	caKeyPath  string
+	caCert     *x509.Certificate
+	caPEM      []byte
+	caKey      crypto.Signer
+	caOnce     sync.Once
+	caErr      error
+)
+
+// CACert return local CA certificate in PEM format, nil if mitm action is not used
+func CACert() []byte {
+	return caPEM
+}
+
+func handleMITM(url.Values) (handlerFunc, error) {
+	if caOnce.Do(func() { caErr = loadCA() }); caErr != nil {
+		return nil, caErr
+	}
+
+	mitmOnce.Do(func() {
+		mitmHandler = serveHTTPS(mitmConfig, http.HandlerFunc(handleMITMRequest))
+	})
+
+	return mitmHandler, nil
+}
+
+// handleMITMRequest pass decrypted requests to HTTP module rules
+func handleMITMRequest(w http.ResponseWriter, r *http.Request) {
+	r.URL.Scheme = "https"
+	r.URL.Host = r.Host
+	r.RequestURI = ""
+
+	ihttp.Handle(w, r)
+}
+
+var (
+	mitmHandler handlerFunc
+	mitmOnce    sync.Once
+)
+
+var mitmConfig = &gotls.Config{
+	GetCertificate: leafCert,
+	NextProtos:     []string{"h2", "http/1.1"},
+}
+
+// leafCerts - certificates for domains, signed by local CA
+var (
+	leafCerts   = map[string]*gotls.Certificate{}
+	leafCertsMu sync.Mutex
+	leafKey     *ecdsa.PrivateKey
+)
+
+func leafCert(hello *gotls.ClientHelloInfo) (*gotls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" {
+		return nil, errors.New("tls: mitm without server name")
+	}
+
+	leafCertsMu.Lock()
+	defer leafCertsMu.Unlock()
+
+	now := time.Now()
+	if cert := leafCerts[host]; cert != nil && leafValid(cert, now) {
+		return cert, nil
+	}
+
+	// remove expired certificates of other domains, so cache doesn't grow forever
+	for name, cert := range leafCerts {
+		if !leafValid(cert, now) {
+			delete(leafCerts, name)
+		}
+	}
+
+	if leafKey == nil {
+		var err error
+		if leafKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 0, 90),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{host},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &gotls.Certificate{Certificate: [][]byte{der, caCert.Raw}, PrivateKey: leafKey, Leaf: leaf}
+	leafCerts[host] = cert
+
+	return cert, nil
+}
+
+// leafValid - certificate is renewed one day before expiration
+func leafValid(cert *gotls.Certificate, now time.Time) bool {
+	return now.Before(cert.Leaf.NotAfter.Add(-24 * time.Hour))
+}
+
+// loadCA read CA from files or generate new one and save it
+func loadCA() error {
+	certPEM, err := os.ReadFile(caCertPath)
+	if err == nil {
+		var keyPEM []byte
+		if keyPEM, err = os.ReadFile(caKeyPath); err != nil {
+			return err
+		}
+		return parseCA(certPEM, keyPEM)
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "pnproxy CA", Organization: []string{"pnproxy"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	if !app.CheckMode {
+		if err = os.WriteFile(caKeyPath, keyPEM, 0o600); err != nil {
+			return err
+		}
+		if err = os.WriteFile(caCertPath, certPEM, 0o644); err != nil {
+			return err
+		}
+		log.Info().Msgf("[tls] new CA certificate path=%s", caCertPath)
+	}
+
+	return parseCA(certPEM, keyPEM)
+}
+
+func parseCA(certPEM, keyPEM []byte) error {
+	pair, err := gotls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("tls: wrong CA key: " + caKeyPath)
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if !cert.IsCA {
+		return errors.New("tls: certificate is not CA: " + caCertPath)
+	}
+
+	caCert, caKey = cert, key
+	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	log.Debug().Msgf("[tls] CA certificate sha256=%x", sha256.Sum256(cert.Raw))
+
+	return nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+// serveIdleTimeout - close keep-alive connections without requests
+const serveIdleTimeout = 90 * time.Second
+
+// httpsServer - own HTTP server of mitm or terminate action, started with TLS module
+type httpsServer struct {
+	srv  *http.Server
+	ln   *connListener
+	once sync.Once
+}
+
+var httpsServers []*httpsServer
+
+// startHTTPS start HTTP servers of all created actions
+func startHTTPS() {
+	for _, s := range httpsServers {
+		s.once.Do(func() {
+			go func() { _ = s.srv.Serve(s.ln) }()
+		})
+	}
+}
+
+// stopHTTPS shutdown HTTP servers of all created actions simultaneously
+func stopHTTPS(ctx context.Context) error {
+	errs := make([]error, len(httpsServers))
+
+	var wg sync.WaitGroup
+	for i, s := range httpsServers {
+		wg.Add(1)
+		go func(i int, s *httpsServer) {
+			defer wg.Done()
+			errs[i] = app.ShutdownServer(ctx, s.srv)
+		}(i, s)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// serveHTTPS return handler that terminates TLS and serves HTTP requests with own HTTP server
+func serveHTTPS(config *gotls.Config, handler http.Handler) handlerFunc {
+	ln := &connListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	srv := &http.Server{Handler: handler, IdleTimeout: serveIdleTimeout}
+	httpsServers = append(httpsServers, &httpsServer{srv: srv, ln: ln})
+
+	return func(src net.Conn, host string, hello []byte) {
+		conn := newReplayConn(src, hello)
+
+		select {
+		case ln.conns <- gotls.Server(conn, config):
+		case <-ln.done:
+			return // server is stopped
+		}
+
+		// wait until HTTP server closes connection
+		<-conn.done
+	}
+}
+
+// replayConn - client connection with already read ClientHello, so TLS server can read it again
+type replayConn struct {
+	net.Conn
+	r    io.Reader
+	done chan struct{}
+	once sync.Once
+}
+
+func newReplayConn(src net.Conn, hello []byte) *replayConn {
+	return &replayConn{
+		Conn: src,
+		r:    io.MultiReader(bytes.NewReader(hello), src),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *replayConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// connListener - listener for connections from TLS module
+type connListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
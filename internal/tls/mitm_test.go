@@ -0,0 +1,122 @@
+package tls
+
+import (
+	"bufio"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	ihttp "github.com/AlexxIT/pnproxy/internal/http"
+	"github.com/stretchr/testify/require"
+)
+
+var appOnce sync.Once
+
+func TestMITM(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello from backend"))
+	}))
+	defer backend.Close()
+
+	// HTTP module transports are cloned from default transport, so they trust backend certificate
+	transport := http.DefaultTransport.(*http.Transport)
+	defer func(config *gotls.Config) { transport.TLSClientConfig = config }(transport.TLSClientConfig)
+	transport.TLSClientConfig = backend.Client().Transport.(*http.Transport).TLSClientConfig
+
+	// HTTP rule forwards decrypted requests to backend, default action doesn't
+	dir := t.TempDir()
+	config := filepath.Join(dir, "pnproxy.yaml")
+	require.Nil(t, os.WriteFile(config, []byte(`http:
+  rules:
+    - name: 127.0.0.1
+      action: raw_pass
+  default:
+    action: redirect code 301
+`), 0o644))
+
+	// app flags can be registered only once, ex. with -count param
+	appOnce.Do(func() {
+		defer func(args []string) { os.Args = args }(os.Args)
+		os.Args = []string{os.Args[0], "-config", config}
+		app.Init()
+	})
+	ihttp.Init()
+	require.Empty(t, app.CheckConfig())
+
+	caCertPath = filepath.Join(dir, "ca.crt")
+	caKeyPath = filepath.Join(dir, "ca.key")
+
+	handler, err := handleMITM(nil)
+	require.Nil(t, err)
+	require.FileExists(t, caCertPath)
+
+	// HTTP servers are started with TLS module
+	startHTTPS()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		src, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer src.Close()
+		hello, _ := readClientHello(src)
+		handler(src, "example.com", hello)
+	}()
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(CACert()))
+
+	conn, err := gotls.Dial("tcp", ln.Addr().String(), &gotls.Config{
+		RootCAs: roots, ServerName: "example.com", NextProtos: []string{"http/1.1"},
+	})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// Host of request is backend address, so it matches HTTP rule
+	req, _ := http.NewRequest("GET", "https://"+backend.Listener.Addr().String()+"/", nil)
+	require.Nil(t, req.Write(conn))
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.Nil(t, err)
+	require.Equal(t, "hello from backend", string(body))
+}
+
+func TestLeafCerts(t *testing.T) {
+	dir := t.TempDir()
+	caCertPath = filepath.Join(dir, "ca.crt")
+	caKeyPath = filepath.Join(dir, "ca.key")
+	require.Nil(t, loadCA())
+
+	leafCerts = map[string]*gotls.Certificate{}
+
+	// expired certificate of other domain is removed with new certificate
+	expired, err := leafCert(&gotls.ClientHelloInfo{ServerName: "old.example.com"})
+	require.Nil(t, err)
+	expired.Leaf.NotAfter = time.Now()
+
+	cert, err := leafCert(&gotls.ClientHelloInfo{ServerName: "new.example.com"})
+	require.Nil(t, err)
+	require.NotContains(t, leafCerts, "old.example.com")
+
+	// valid certificate is reused
+	cert2, err := leafCert(&gotls.ClientHelloInfo{ServerName: "new.example.com"})
+	require.Nil(t, err)
+	require.Same(t, cert, cert2)
+}
@@ -0,0 +1,249 @@
+package tls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	quicV1 = 0x00000001
+	quicV2 = 0x6b3343cf
+)
+
+// initial salts, https://datatracker.ietf.org/doc/html/rfc9001#section-5.2
+var (
+	quicV1Salt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	quicV2Salt = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+var errQUICInitial = errors.New("quic: wrong initial packet")
+
+// cryptoFrame - part of TLS handshake data from QUIC CRYPTO frame
+type cryptoFrame struct {
+	offset int
+	data   []byte
+}
+
+// parseQUICInitial decrypt client Initial packets from UDP datagram and return CRYPTO frames,
+// https://datatracker.ietf.org/doc/html/rfc9001#section-5
+func parseQUICInitial(datagram []byte) (frames []cryptoFrame, err error) {
+	for b := datagram; len(b) > 0; {
+		// Initial packets are always first in datagram, stop on other packets
+		if b[0]&0x80 == 0 {
+			break
+		}
+
+		r := &reader{b: b[1:]}
+		version := uint32(r.u16())<<16 | uint32(r.u16())
+
+		var packetType byte
+		switch version {
+		case quicV1:
+			packetType = 0
+		case quicV2:
+			packetType = 1
+		default:
+			return nil, errQUICInitial
+		}
+
+		if (b[0]>>4)&0x03 != packetType {
+			break
+		}
+
+		dcid := r.vec8()
+		r.vec8()                 // source connection ID
+		r.bytes(int(r.varint())) // token
+		length := int(r.varint())
+
+		pnOffset := len(b) - r.len()
+		if r.err || length < 20 || length > r.len() {
+			return nil, errQUICInitial
+		}
+
+		payload, err := decryptInitial(version, dcid, b[:pnOffset+length], pnOffset)
+		if err != nil {
+			return nil, err
+		}
+
+		if frames, err = appendCryptoFrames(frames, payload); err != nil {
+			return nil, err
+		}
+
+		b = b[pnOffset+length:]
+	}
+
+	if frames == nil {
+		return nil, errQUICInitial
+	}
+
+	return frames, nil
+}
+
+func decryptInitial(version uint32, dcid, packet []byte, pnOffset int) ([]byte, error) {
+	salt, prefix := quicV1Salt, "quic "
+	if version == quicV2 {
+		salt, prefix = quicV2Salt, "quicv2 "
+	}
+
+	initial := hkdf.Extract(sha256.New, dcid, salt)
+	secret := expandLabel(initial, "client in", 32)
+
+	hp, err := aes.NewCipher(expandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(expandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// don't change original packet, it will be forwarded to server
+	packet = slices.Clone(packet)
+
+	// remove header protection, https://datatracker.ietf.org/doc/html/rfc9001#section-5.4
+	mask := make([]byte, aes.BlockSize)
+	hp.Encrypt(mask, packet[pnOffset+4:pnOffset+4+aes.BlockSize])
+
+	packet[0] ^= mask[0] & 0x0f
+	pnLen := int(packet[0]&0x03) + 1
+
+	nonce := expandLabel(secret, prefix+"iv", 12)
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+		nonce[len(nonce)-pnLen+i] ^= packet[pnOffset+i]
+	}
+
+	header := packet[:pnOffset+pnLen]
+	return aead.Open(nil, nonce, packet[len(header):], header)
+}
+
+// expandLabel - HKDF-Expand-Label from TLS 1.3 with empty context
+func expandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+
+	b := make([]byte, length)
+	_, _ = io.ReadFull(hkdf.Expand(sha256.New, secret, info), b)
+	return b
+}
+
+// appendCryptoFrames parse frames allowed in Initial packets and collect CRYPTO frames
+func appendCryptoFrames(frames []cryptoFrame, payload []byte) ([]cryptoFrame, error) {
+	r := &reader{b: payload}
+	for r.len() > 0 {
+		switch frameType := r.varint(); frameType {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			r.varint() // largest acknowledged
+			r.varint() // delay
+			count := r.varint()
+			r.varint() // first range
+			for i := uint64(0); i < count && !r.err; i++ {
+				r.varint() // gap
+				r.varint() // range
+			}
+			if frameType == 0x03 {
+				r.varint() // ECN counts
+				r.varint()
+				r.varint()
+			}
+		case 0x06: // CRYPTO
+			offset := r.varint()
+			data := r.bytes(int(r.varint()))
+			if offset > maxHelloSize {
+				return nil, errQUICInitial
+			}
+			frames = append(frames, cryptoFrame{offset: int(offset), data: data})
+		case 0x1c: // CONNECTION_CLOSE
+			r.varint() // error code
+			r.varint() // frame type
+			r.bytes(int(r.varint()))
+		default:
+			return nil, errQUICInitial
+		}
+	}
+
+	if r.err {
+		return nil, errQUICInitial
+	}
+
+	return frames, nil
+}
+
+// cryptoHello join CRYPTO frames and return ClientHello as TLS records, nil if it is not complete
+func cryptoHello(frames []cryptoFrame) []byte {
+	frames = slices.Clone(frames)
+	slices.SortFunc(frames, func(a, b cryptoFrame) int {
+		return a.offset - b.offset
+	})
+
+	var data []byte
+	for _, f := range frames {
+		if f.offset > len(data) {
+			break
+		}
+		if end := f.offset + len(f.data); end > len(data) {
+			data = append(data, f.data[len(data)-f.offset:]...)
+		}
+	}
+
+	if len(data) < 4 {
+		return nil
+	}
+
+	n := 4 + (int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+	if len(data) < n {
+		return nil
+	}
+
+	return buildRecords([]byte{0x03, 0x03}, data[:n], nil)
+}
+
+// varint - QUIC variable-length integer, https://datatracker.ietf.org/doc/html/rfc9000#section-16
+func (r *reader) varint() uint64 {
+	if r.len() == 0 {
+		r.err = true
+		return 0
+	}
+
+	n := 1 << (r.b[0] >> 6)
+	b := r.bytes(n)
+	if b == nil {
+		return 0
+	}
+
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// isQUICInitial check long header of Initial packet without decryption
+func isQUICInitial(b []byte) bool {
+	if len(b) < 5 || b[0]&0xc0 != 0xc0 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(b[1:]) {
+	case quicV1:
+		return (b[0]>>4)&0x03 == 0
+	case quicV2:
+		return (b[0]>>4)&0x03 == 1
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package tls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"testing"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestQUICKeys(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc9001#appendix-A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	initial := hkdf.Extract(sha256.New, dcid, quicV1Salt)
+	secret := expandLabel(initial, "client in", 32)
+
+	require.Equal(t, "c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea", hex.EncodeToString(secret))
+	require.Equal(t, "1f369613dd76d5467730efcbe3b1a22d", hex.EncodeToString(expandLabel(secret, "quic key", 16)))
+	require.Equal(t, "fa044b2f42a3fd3b46fb255c", hex.EncodeToString(expandLabel(secret, "quic iv", 12)))
+	require.Equal(t, "9f50449e04a0e810283a1e9933adedd2", hex.EncodeToString(expandLabel(secret, "quic hp", 16)))
+}
+
+func TestQUICInitial(t *testing.T) {
+	hello, _ := hex.DecodeString(testHello)
+	payload := recordsPayload(hello)
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// ClientHello in two packets, second part goes first like with Chrome
+	crypto := func(offset int, data []byte) []byte {
+		b := []byte{0x06, 0x40 | byte(offset>>8), byte(offset), 0x40 | byte(len(data)>>8), byte(len(data))}
+		return append(b, data...)
+	}
+	packet1 := testQUICPacket(dcid, 0, crypto(300, payload[300:]))
+	packet2 := testQUICPacket(dcid, 1, append([]byte{0x01, 0x00, 0x00}, crypto(0, payload[:300])...))
+
+	frames, err := parseQUICInitial(packet1)
+	require.Nil(t, err)
+	require.Nil(t, cryptoHello(frames))
+
+	frames2, err := parseQUICInitial(packet2)
+	require.Nil(t, err)
+
+	b := cryptoHello(append(frames, frames2...))
+	require.NotNil(t, b)
+
+	h, err := ParseClientHello(b)
+	require.Nil(t, err)
+	require.Equal(t, "habr.com", h.ServerName)
+
+	// packet for other connection ID
+	packet1[6] ^= 0xff
+	_, err = parseQUICInitial(packet1)
+	require.NotNil(t, err)
+}
+
+// testQUICPacket build protected client Initial packet QUIC v1
+func testQUICPacket(dcid []byte, pn byte, frames []byte) []byte {
+	initial := hkdf.Extract(sha256.New, dcid, quicV1Salt)
+	secret := expandLabel(initial, "client in", 32)
+
+	// one byte packet number
+	length := 1 + len(frames) + 16
+	b := []byte{0xc0, 0, 0, 0, 1, byte(len(dcid))}
+	b = append(b, dcid...)
+	b = append(b, 0, 0, 0x40|byte(length>>8), byte(length))
+	pnOffset := len(b)
+	b = append(b, pn)
+
+	nonce := expandLabel(secret, "quic iv", 12)
+	nonce[11] ^= pn
+
+	block, _ := aes.NewCipher(expandLabel(secret, "quic key", 16))
+	aead, _ := cipher.NewGCM(block)
+	b = aead.Seal(b, nonce, frames, b)
+
+	hp, _ := aes.NewCipher(expandLabel(secret, "quic hp", 16))
+	mask := make([]byte, 16)
+	hp.Encrypt(mask, b[pnOffset+4:pnOffset+20])
+	b[0] ^= mask[0] & 0x0f
+	b[pnOffset] ^= mask[1]
+
+	return b
+}
+
+func TestNewQUIC(t *testing.T) {
+	quic, err := newQUIC(&app.Action{Name: "raw_pass"})
+	require.Nil(t, err)
+	require.NotNil(t, quic)
+
+	quic, err = newQUIC(&app.Action{Name: "proxy_pass"})
+	require.Nil(t, err)
+	require.Nil(t, quic)
+
+	_, err = newQUIC(&app.Action{Name: "raw_pass", Params: url.Values{"interface": {"unknown0"}}})
+	require.NotNil(t, err)
+}
@@ -80,3 +80,31 @@ func Benchmark_parseSNI(b *testing.B) {
 		})
 	}
 }
+
+func TestReadClientHelloRecords(t *testing.T) {
+	hello, _ := hex.DecodeString(testHello)
+
+	// ClientHello in three records with extra data after it
+	b := buildRecords(hello[1:3], recordsPayload(hello), []int{3, 140})
+	r := bytes.NewReader(append(b, 0x17, 0x03, 0x03))
+
+	hello2, err := readClientHello(r)
+	require.Nil(t, err)
+	require.Equal(t, b, hello2)
+	require.Equal(t, "habr.com", parseSNI(hello2))
+
+	// not a ClientHello message
+	b = append([]byte{}, hello...)
+	b[5] = 0x02
+	_, err = readClientHello(bytes.NewReader(b))
+	require.EqualError(t, err, "tls: not a client hello")
+
+	// message is bigger than limit
+	b = []byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x01, 0xFF, 0xFF, 0xFF}
+	_, err = readClientHello(bytes.NewReader(b))
+	require.EqualError(t, err, "tls: too big handshake")
+
+	// not finished message
+	_, err = readClientHello(bytes.NewReader(hello[:100]))
+	require.NotNil(t, err)
+}
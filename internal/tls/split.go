@@ -0,0 +1,275 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultLadder - split_pass strategies without strategy param
+var defaultLadder = []string{"bytes", "bytes:3ms", "bytes:6ms"}
+
+// splitStrategy - ClientHello records and the way to send it to server.
+// Handshake data is never changed, because server checks it in the handshake transcript.
+type splitStrategy struct {
+	name    string
+	records []int // nil - one record, empty - split at SNI
+	write   func(dst net.Conn, hello []byte, host string) error
+	tcp     bool // write needs direct TCP connection
+}
+
+// parseStrategy parse strategy like "record+sni", only one write method is allowed
+func parseStrategy(s string) (*splitStrategy, error) {
+	strategy := &splitStrategy{name: s}
+
+	for _, part := range strings.Split(s, "+") {
+		name, arg, _ := strings.Cut(part, ":")
+
+		var write func(dst net.Conn, hello []byte, host string) error
+
+		switch name {
+		case "record":
+			sizes, err := parseSizes(arg)
+			if err != nil {
+				return nil, err
+			}
+			strategy.records = sizes
+		case "bytes":
+			var delay time.Duration
+			if arg != "" {
+				var err error
+				if delay, err = time.ParseDuration(arg); err != nil || delay < 0 {
+					return nil, errors.New("wrong bytes delay: " + arg)
+				}
+			}
+			write = func(dst net.Conn, hello []byte, _ string) error {
+				return writeSplit(dst, hello, delay)
+			}
+		case "sni":
+			write = func(dst net.Conn, hello []byte, host string) error {
+				return writeSegments(dst, hello, []int{recordsSNIOffset(hello, host)})
+			}
+		case "segments":
+			sizes, err := parseSizes(arg)
+			if err != nil || len(sizes) == 0 {
+				return nil, errors.New("wrong segments sizes: " + arg)
+			}
+			write = func(dst net.Conn, hello []byte, _ string) error {
+				return writeSegments(dst, hello, sizes)
+			}
+		case "disorder":
+			strategy.tcp = true
+			write = func(dst net.Conn, hello []byte, host string) error {
+				return writeDisorder(dst, hello, recordsSNIOffset(hello, host))
+			}
+		default:
+			return nil, errors.New("unknown strategy: " + part)
+		}
+
+		if write != nil {
+			if strategy.write != nil {
+				return nil, errors.New("only one write method in strategy: " + s)
+			}
+			strategy.write = write
+		}
+	}
+
+	return strategy, nil
+}
+
+func parseSizes(s string) (sizes []int, err error) {
+	sizes = []int{}
+	if s == "" {
+		return
+	}
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, errors.New("wrong size: " + field)
+		}
+		sizes = append(sizes, size)
+	}
+	return
+}
+
+// apply return ClientHello records for sending with strategy
+func (s *splitStrategy) apply(hello []byte, host string) []byte {
+	if s.records == nil {
+		return hello
+	}
+
+	payload := recordsPayload(hello)
+	if payload == nil {
+		return hello
+	}
+
+	sizes := s.records
+	if sizes != nil && len(sizes) == 0 {
+		sizes = []int{sniOffset(payload, host)}
+	}
+
+	return buildRecords(hello[1:3], payload, sizes)
+}
+
+func (s *splitStrategy) send(dst net.Conn, hello []byte, host string) error {
+	hello = s.apply(hello, host)
+
+	if s.write != nil {
+		return s.write(dst, hello, host)
+	}
+
+	_, err := dst.Write(hello)
+	return err
+}
+
+// recordsPayload join handshake data from all TLS records
+func recordsPayload(hello []byte) (payload []byte) {
+	for len(hello) >= 5 {
+		if hello[0] != 0x16 {
+			return nil
+		}
+		n := 5 + int(binary.BigEndian.Uint16(hello[3:]))
+		if n > len(hello) {
+			return nil
+		}
+		payload = append(payload, hello[5:n]...)
+		hello = hello[n:]
+	}
+	return
+}
+
+// buildRecords split handshake data to TLS records with sizes, last record has the rest of data
+func buildRecords(version, payload []byte, sizes []int) []byte {
+	var b []byte
+	for _, size := range sizes {
+		if size >= len(payload) {
+			break
+		}
+		b = appendRecord(b, version, payload[:size])
+		payload = payload[size:]
+	}
+	for len(payload) > maxRecordSize {
+		b = appendRecord(b, version, payload[:maxRecordSize])
+		payload = payload[maxRecordSize:]
+	}
+	return appendRecord(b, version, payload)
+}
+
+const maxRecordSize = 1 << 14
+
+func appendRecord(b, version, data []byte) []byte {
+	b = append(b, 0x16, version[0], version[1], byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
+
+// sniOffset return offset of the middle of server name or middle of data
+func sniOffset(b []byte, host string) int {
+	if host != "" {
+		for i := 0; i+len(host) <= len(b); i++ {
+			if bytes.EqualFold(b[i:i+len(host)], []byte(host)) {
+				return i + len(host)/2
+			}
+		}
+	}
+	return len(b) / 2
+}
+
+// recordsSNIOffset return sniOffset in ClientHello records, server name can be split
+// between records by record strategy or fragment param
+func recordsSNIOffset(hello []byte, host string) int {
+	payload := recordsPayload(hello)
+	if payload == nil {
+		return sniOffset(hello, host)
+	}
+
+	// skip headers of records before offset
+	offset := sniOffset(payload, host)
+	var i int
+	for len(hello) >= 5 {
+		n := int(binary.BigEndian.Uint16(hello[3:]))
+		i += 5
+		if offset <= n {
+			return i + offset
+		}
+		offset -= n
+		i += n
+		hello = hello[5+n:]
+	}
+	return i
+}
+
+// writeSegments write data in separate TCP segments with sizes, last segment has the rest of data
+func writeSegments(conn net.Conn, b []byte, sizes []int) error {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetNoDelay(true)
+	}
+
+	for _, size := range sizes {
+		if size >= len(b) {
+			break
+		}
+		if _, err := conn.Write(b[:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+
+	_, err := conn.Write(b)
+	return err
+}
+
+// writeDisorder send first part with low TTL, so it is lost and then retransmitted by OS after second part
+func writeDisorder(conn net.Conn, b []byte, offset int) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		log.Warn().Msgf("[tls] disorder without TCP connection, send segments in order")
+		return writeSegments(conn, b, []int{offset})
+	}
+
+	_ = tcp.SetNoDelay(true)
+
+	ttl, err := setTTL(tcp, 1)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(b[:offset])
+
+	// restore TTL for retransmission
+	if _, err2 := setTTL(tcp, ttl); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(b[offset:])
+	return err
+}
+
+func writeSplit(conn net.Conn, hello []byte, delay time.Duration) error {
+	if delay == 0 {
+		for _, b := range hello {
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return err
+			}
+		}
+	} else {
+		t0 := time.Now()
+		for i, b := range hello {
+			if dt := t0.Add(time.Duration(i) * delay).Sub(time.Now()); dt > 0 {
+				time.Sleep(dt)
+			}
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
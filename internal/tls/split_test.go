@@ -0,0 +1,162 @@
+package tls
+
+import (
+	"bytes"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testHello = "1603010200010001fc0303802dfbd5b002be713804193f683bbbf9a1c9673993c5561eb0eecf1e0ce387b9200b9c0335c7752ad641ee7bbb8037c7534d8b4c001cda75c6b788a53dc4c47b8c002a3a3a130113021303c02cc02bcca9c030c02fcca8c00ac009c014c013009d009c0035002fc008c012000a010001895a5a00000000000d000b000008686162722e636f6d00170000ff01000100000a000c000ababa001d001700180019000b000201000010000b000908687474702f312e31000500050100000000000d0018001604030804040105030203080508050501080606010201001200000033002b0029baba000100001d0020365e72276a10052ecc8e4712f6da8ce322946757a4a3c2377c211935b447e861002d00020101002b000b0a0a0a0304030303020301001b00030200011a1a000100001500c9000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"
+
+func TestSplitStrategy(t *testing.T) {
+	hello, _ := hex.DecodeString(testHello)
+
+	_, err := parseStrategy("sni+disorder")
+	require.NotNil(t, err)
+	_, err = parseStrategy("segments")
+	require.NotNil(t, err)
+	_, err = parseStrategy("bytes:abc")
+	require.NotNil(t, err)
+
+	// upstream connection can't change TTL of packets
+	_, err = newSplit(url.Values{"via": {"direct"}, "strategy": {"disorder"}})
+	require.EqualError(t, err, "strategy can't be used with via: disorder")
+
+	// record fragmentation at SNI
+	s, err := parseStrategy("record")
+	require.Nil(t, err)
+	b := s.apply(hello, "habr.com")
+	require.Equal(t, len(hello)+5, len(b))
+	require.Equal(t, recordsPayload(hello), recordsPayload(b))
+	require.NotContains(t, string(b), "habr.com")
+
+	// record fragmentation with sizes
+	s, err = parseStrategy("record:10,20")
+	require.Nil(t, err)
+	b = s.apply(hello, "habr.com")
+	require.Equal(t, len(hello)+10, len(b))
+	require.Equal(t, recordsPayload(hello), recordsPayload(b))
+
+	hello2, err := readClientHello(bytes.NewReader(b))
+	require.Nil(t, err)
+	require.Equal(t, b, hello2)
+
+	// server name is found in fragmented records
+	offset := recordsSNIOffset(hello, "habr.com")
+	require.Equal(t, "habr", string(hello[offset-4:offset]))
+	for _, name := range []string{"record", "record:10", "record:1,1,1,1"} {
+		s, err = parseStrategy(name)
+		require.Nil(t, err)
+		b = s.apply(hello, "habr.com")
+		offset = recordsSNIOffset(b, "habr.com")
+		require.Equal(t, "habr", string(b[offset-4:offset]), name)
+	}
+
+	// original hello is not changed
+	require.Equal(t, "habr.com", parseSNI(hello))
+
+	// all write methods send the same data
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	for _, name := range []string{"sni", "segments:1,5", "disorder", "bytes"} {
+		s, err = parseStrategy(name)
+		require.Nil(t, err)
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.Nil(t, err)
+
+		srv, err := ln.Accept()
+		require.Nil(t, err)
+
+		require.Nil(t, s.send(conn, hello, "habr.com"))
+
+		b = make([]byte, len(hello))
+		_, err = io.ReadFull(srv, b)
+		require.Nil(t, err)
+		require.Equal(t, hello, b, name)
+
+		_ = conn.Close()
+		_ = srv.Close()
+	}
+}
+
+// TestSplitHandshake - server must finish real TLS handshake with every strategy
+func TestSplitHandshake(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	for _, name := range []string{
+		"record", "record:10,20", "bytes", "sni", "segments:1,5", "disorder", "record+sni", "record:10+disorder",
+	} {
+		s, err := parseStrategy(name)
+		require.Nil(t, err)
+
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.Nil(t, err)
+
+		tconn := gotls.Client(&strategyConn{Conn: conn, s: s, host: "example.com"}, &gotls.Config{
+			ServerName: "example.com", RootCAs: roots,
+		})
+		require.Nil(t, tconn.Handshake(), name)
+
+		_ = tconn.Close()
+	}
+}
+
+// strategyConn send first write (ClientHello) with split strategy
+type strategyConn struct {
+	net.Conn
+	s    *splitStrategy
+	host string
+	sent bool
+}
+
+func (c *strategyConn) Write(b []byte) (int, error) {
+	if c.sent {
+		return c.Conn.Write(b)
+	}
+	c.sent = true
+	if err := c.s.send(c.Conn, b, c.host); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func TestFragment(t *testing.T) {
+	hello, _ := hex.DecodeString(testHello)
+
+	var got []byte
+	handle := func(params url.Values) (handlerFunc, error) {
+		return withFragment(func(_ net.Conn, _ string, hello []byte) {
+			got = hello
+		}, params)
+	}
+
+	handler, err := handle(url.Values{"fragment": {"100,200"}})
+	require.Nil(t, err)
+	handler(nil, "habr.com", hello)
+	require.Equal(t, len(hello)+10, len(got))
+	require.Equal(t, recordsPayload(hello), recordsPayload(got))
+
+	handler, err = handle(url.Values{"fragment": {"sni"}})
+	require.Nil(t, err)
+	handler(nil, "habr.com", hello)
+	require.NotContains(t, string(got), "habr.com")
+
+	_, err = handle(url.Values{"fragment": {"abc"}})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,182 @@
+package tls
+
+import (
+	"context"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	ihttp "github.com/AlexxIT/pnproxy/internal/http"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var terminateParams = slices.Concat(upstream.DirectParams, []app.Param{
+	{Name: "host", Required: true},
+	{Name: "port", Required: true},
+	{Name: "type", Values: []string{"http", "tcp"}},
+	{Name: "cert"},
+	{Name: "key"},
+})
+
+// handleTerminate serve TLS with certificate from files or ACME and pass plain HTTP or TCP to backend
+func handleTerminate(params url.Values) (handlerFunc, error) {
+	dialer, err := upstream.Direct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &gotls.Config{}
+
+	switch certFile, keyFile := params.Get("cert"), params.Get("key"); {
+	case certFile != "" && keyFile != "":
+		cert, err := gotls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []gotls.Certificate{cert}
+	case certFile == "" && keyFile == "":
+		m, err := acmeManager()
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = m.GetCertificate
+		config.NextProtos = []string{acme.ALPNProto}
+	default:
+		return nil, errors.New("missing param: cert and key")
+	}
+
+	address := net.JoinHostPort(params.Get("host"), params.Get("port"))
+
+	if params.Get("type") == "tcp" {
+		return func(src net.Conn, host string, hello []byte) {
+			conn := gotls.Server(newReplayConn(src, hello), config)
+			defer conn.Close()
+
+			// client can stop sending handshake, ACME certificate can take a long time
+			ctx, cancel := context.WithTimeout(clientContext(src), terminateHandshakeTimeout)
+			err := conn.HandshakeContext(ctx)
+			cancel()
+			if err != nil {
+				log.Debug().Err(err).Msgf("[tls] terminate domain=%s", host)
+				return
+			}
+
+			// TLS-ALPN-01 challenge is finished after handshake
+			if conn.ConnectionState().NegotiatedProtocol == acme.ALPNProto {
+				return
+			}
+
+			dst, err := dialer.DialContext(clientContext(src), "tcp", address)
+			if err != nil {
+				log.Warn().Err(err).Caller().Send()
+				return
+			}
+			defer dst.Close()
+
+			pipe(conn, dst)
+		}, nil
+	}
+
+	config.NextProtos = append(config.NextProtos, "h2", "http/1.1")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	target := &url.URL{Scheme: "http", Host: address}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			r.Out.Host = r.In.Host
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Warn().Err(err).Caller().Send()
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return serveHTTPS(config, proxy), nil
+}
+
+// terminateHandshakeTimeout - TLS handshake with client, including ACME certificate order
+const terminateHandshakeTimeout = time.Minute
+
+// ACME settings and domains from terminate rules without certificate files
+var (
+	acmeEmail     string
+	acmeDirectory string
+	acmeCA        string
+	acmeCache     string
+	acmeDomains   []string
+)
+
+var (
+	acmeOnce sync.Once
+	acmeM    *autocert.Manager
+	acmeErr  error
+)
+
+// acmeManager create one manager for all terminate actions, it renews certificates in background
+func acmeManager() (*autocert.Manager, error) {
+	acmeOnce.Do(func() {
+		client := &acme.Client{DirectoryURL: acmeDirectory}
+
+		// custom CA for ACME server, ex. Pebble for tests
+		if acmeCA != "" {
+			b, err := os.ReadFile(acmeCA)
+			if err != nil {
+				acmeErr = err
+				return
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(b) {
+				acmeErr = errors.New("wrong acme ca: " + acmeCA)
+				return
+			}
+
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = &gotls.Config{RootCAs: pool}
+			client.HTTPClient = &http.Client{Transport: transport}
+		}
+
+		acmeM = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(acmeCache),
+			HostPolicy: acmePolicy,
+			Client:     client,
+			Email:      acmeEmail,
+		}
+
+		// HTTP-01 challenges through HTTP module listener
+		ihttp.HandleACME(acmeM.HTTPHandler(http.NotFoundHandler()))
+	})
+
+	return acmeM, acmeErr
+}
+
+// acmePolicy allow certificates only for exact domains from terminate rules,
+// so clients can't request certificates for any subdomain
+func acmePolicy(_ context.Context, host string) error {
+	for _, domain := range acmeDomains {
+		if strings.EqualFold(host, domain) {
+			return nil
+		}
+	}
+	return errors.New("acme: domain not in terminate rules: " + host)
+}
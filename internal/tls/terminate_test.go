@@ -0,0 +1,109 @@
+package tls
+
+import (
+	"context"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminate(t *testing.T) {
+	dir := t.TempDir()
+	caCertPath = filepath.Join(dir, "ca.crt")
+	caKeyPath = filepath.Join(dir, "ca.key")
+	require.Nil(t, loadCA())
+
+	// certificate files for backend domain, signed by test CA
+	cert, err := leafCert(&gotls.ClientHelloInfo{ServerName: "ha.home.example"})
+	require.Nil(t, err)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	certFile, keyFile := filepath.Join(dir, "ha.crt"), filepath.Join(dir, "ha.key")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host+" "+r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	_, err = handleTerminate(url.Values{"host": {host}, "port": {port}, "cert": {certFile}})
+	require.NotNil(t, err)
+
+	handler, err := handleTerminate(url.Values{"host": {host}, "port": {port}, "cert": {certFile}, "key": {keyFile}})
+	require.Nil(t, err)
+
+	// HTTP servers are started with TLS module
+	startHTTPS()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			src, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer src.Close()
+				hello, _ := readClientHello(src)
+				handler(src, "ha.home.example", hello)
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(CACert())
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &gotls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, ln.Addr().String())
+		},
+	}}
+
+	res, err := client.Get("https://ha.home.example/")
+	require.Nil(t, err)
+	b, _ := io.ReadAll(res.Body)
+	require.Equal(t, "ha.home.example https", string(b))
+	require.Equal(t, 2, res.ProtoMajor)
+}
+
+func TestACMEPolicy(t *testing.T) {
+	// ACME server shouldn't get any requests
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	acmeDirectory, acmeCache = server.URL, t.TempDir()
+	acmeDomains = []string{"ha.home.example"}
+	acmeOnce = sync.Once{}
+	defer func() { acmeDomains, acmeOnce = nil, sync.Once{} }()
+
+	m, err := acmeManager()
+	require.Nil(t, err)
+
+	_, err = m.GetCertificate(&gotls.ClientHelloInfo{ServerName: "www.ha.home.example"})
+	require.EqualError(t, err, "acme: domain not in terminate rules: www.ha.home.example")
+	require.Zero(t, requests)
+
+	require.Nil(t, acmePolicy(context.Background(), "ha.home.example"))
+}
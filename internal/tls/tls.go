@@ -1,64 +1,180 @@
 package tls
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/clients"
 	"github.com/AlexxIT/pnproxy/internal/hosts"
+	"github.com/AlexxIT/pnproxy/internal/proxyproto"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/net/proxy"
 )
 
 func Init() {
 	var cfg struct {
 		TLS struct {
-			Listen string `yaml:"listen"`
-			Rules  []struct {
-				Name   string `yaml:"name"`
-				Action string `yaml:"action"`
+			Listen        string        `yaml:"listen"`
+			ProxyProtocol string        `yaml:"proxy_protocol"`
+			DrainTimeout  time.Duration `yaml:"drain_timeout"`
+			ECH           string        `yaml:"ech"`
+			ECHPublic     string        `yaml:"ech_public"`
+			QUICListen    string        `yaml:"quic_listen"`
+			QUICTimeout   time.Duration `yaml:"quic_timeout"`
+			CA            struct {
+				Cert string `yaml:"cert"`
+				Key  string `yaml:"key"`
+			} `yaml:"ca"`
+			ACME struct {
+				Email     string `yaml:"email"`
+				Directory string `yaml:"directory"`
+				CA        string `yaml:"ca"`
+				Cache     string `yaml:"cache"`
+			} `yaml:"acme"`
+			Rules []struct {
+				Name   string     `yaml:"name"`
+				ALPN   string     `yaml:"alpn"`
+				JA3    string     `yaml:"ja3"`
+				JA4    string     `yaml:"ja4"`
+				Client string     `yaml:"client"`
+				Action app.Action `yaml:"action"`
 			}
 			Default struct {
-				Action string `yaml:"action"`
+				Action app.Action `yaml:"action"`
 			} `yaml:"default"`
 		} `yaml:"tls"`
 	}
 
-	cfg.TLS.Default.Action = "raw_pass"
+	initActions()
+
+	cfg.TLS.DrainTimeout = 10 * time.Second
+	cfg.TLS.ECHPublic = "cloudflare-ech.com"
+	cfg.TLS.QUICTimeout = 60 * time.Second
+	cfg.TLS.CA.Cert = app.ConfigFile("pnproxy.ca.crt")
+	cfg.TLS.CA.Key = app.ConfigFile("pnproxy.ca.key")
+	cfg.TLS.ACME.Cache = app.ConfigFile("pnproxy.acme")
+	cfg.TLS.Default.Action = app.Action{Name: "raw_pass"}
 
 	app.LoadConfig(&cfg)
 
-	for _, rule := range cfg.TLS.Rules {
-		handler := parseAction(rule.Action)
-		if handler == nil {
-			log.Warn().Msgf("[tls] wrong action: %s", rule.Action)
+	drainTimeout = cfg.TLS.DrainTimeout
+	caCertPath, caKeyPath = cfg.TLS.CA.Cert, cfg.TLS.CA.Key
+	acmeEmail, acmeDirectory, acmeCA, acmeCache = cfg.TLS.ACME.Email, cfg.TLS.ACME.Directory, cfg.TLS.ACME.CA, cfg.TLS.ACME.Cache
+
+	var err error
+	if echBlock, echDomains, err = parseECH(cfg.TLS.ECH); err != nil {
+		app.ConfigError(fmt.Errorf("[tls] wrong ech: %w", err))
+	}
+	if echPublic, err = parseDomains(cfg.TLS.ECHPublic); err != nil {
+		app.ConfigError(fmt.Errorf("[tls] wrong ech_public: %w", err))
+	}
+
+	for _, r := range cfg.TLS.Rules {
+		handler, err := actions.New(&r.Action)
+		if err != nil {
+			app.ConfigError(r.Action.Errorf("[tls] %w", err))
+			continue
+		}
+
+		clientNets, err := clients.Get(r.Client)
+		if err != nil {
+			app.ConfigError(r.Action.Errorf("[tls] %w", err))
+			continue
+		}
+
+		quic, err := newQUIC(&r.Action)
+		if err != nil {
+			app.ConfigError(r.Action.Errorf("[tls] %w", err))
 			continue
 		}
 
-		for _, name := range hosts.Get(rule.Name) {
-			handlers["."+name] = handler
+		rule := &rule{
+			alpn:    strings.Fields(r.ALPN),
+			ja3:     strings.Fields(r.JA3),
+			ja4:     strings.Fields(r.JA4),
+			clients: clientNets,
+			handler: handler,
+			quic:    quic,
+		}
+		for _, name := range hosts.Get(r.Name) {
+			rule.domains = append(rule.domains, "."+name)
+		}
+		rules = append(rules, rule)
+
+		// certificates are issued only for exact names, not for their subdomains
+		if r.Action.Name == "terminate" && !r.Action.Params.Has("cert") {
+			acmeDomains = append(acmeDomains, hosts.Get(r.Name)...)
+		}
+	}
+
+	if cfg.TLS.Default.Action.Name != "" {
+		if defaultHandler, err = actions.New(&cfg.TLS.Default.Action); err != nil {
+			app.ConfigError(cfg.TLS.Default.Action.Errorf("[tls] %w", err))
+		} else if defaultQUIC, err = newQUIC(&cfg.TLS.Default.Action); err != nil {
+			app.ConfigError(cfg.TLS.Default.Action.Errorf("[tls] %w", err))
 		}
 	}
 
-	defaultHandler = parseAction(cfg.TLS.Default.Action)
+	// mitm and terminate actions have own HTTP servers, also for connections from other modules
+	if cfg.TLS.Listen != "" || httpsServers != nil {
+		trusted, err := clients.Get(cfg.TLS.ProxyProtocol)
+		if err != nil {
+			app.ConfigError(fmt.Errorf("[tls] wrong proxy_protocol: %w", err))
+		}
 
-	if cfg.TLS.Listen != "" {
-		go serve(cfg.TLS.Listen)
+		var ln net.Listener
+		app.OnStart("tls", func() error {
+			if cfg.TLS.Listen != "" {
+				var err error
+				if ln, err = net.Listen("tcp", cfg.TLS.Listen); err != nil {
+					return err
+				}
+				go serve(proxyproto.Listen(ln, trusted))
+			}
+			startHTTPS()
+			return nil
+		})
+		app.OnStop("tls", func(ctx context.Context) error {
+			if ln != nil {
+				_ = ln.Close()
+			}
+
+			// HTTP servers close keep-alive connections, so drain doesn't wait for them
+			done := make(chan error)
+			go func() { done <- stopHTTPS(ctx) }()
+
+			Drain(ctx)
+			return <-done
+		})
+	}
+
+	if cfg.TLS.QUICListen != "" {
+		if cfg.TLS.QUICTimeout > 0 {
+			initQUIC(cfg.TLS.QUICListen, cfg.TLS.QUICTimeout)
+		} else {
+			app.ConfigError(fmt.Errorf("[tls] wrong quic_timeout: %s", cfg.TLS.QUICTimeout))
+		}
 	}
 }
 
 type handlerFunc func(src net.Conn, host string, hello []byte)
 
-var handlers = map[string]handlerFunc{}
-var defaultHandler handlerFunc
-
 func Handle(src net.Conn) {
 	defer src.Close()
 
+	if !addConn(src) {
+		return // app is stopping
+	}
+	defer delConn(src)
+
 	remote := src.RemoteAddr().String()
 
 	hello, err := readClientHello(src)
@@ -67,84 +183,244 @@ func Handle(src net.Conn) {
 		return
 	}
 
-	domain := parseSNI(hello)
+	ch, err := ParseClientHello(hello)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[tls] skip remote_addr=%s data=%x", remote, hello)
+		return
+	}
+
+	domain := ch.ServerName
 	if domain == "" {
 		log.Warn().Msgf("[tls] skip empty domain remote_addr=%s data=%x", remote, hello)
 		return
 	}
 
-	handler := findHandler(domain)
+	conn := setConnHello(src, ch)
+
+	match, ok := echMatch(ch)
+	if !ok {
+		log.Debug().Msgf("[tls] block ech remote_addr=%s domain=%s", remote, domain)
+		return
+	}
+
+	handler := findHandler(conn, match)
 	if handler == nil {
 		log.Trace().Msgf("[tls] skip remote_addr=%s domain=%s", remote, domain)
 		return
 	}
 
-	log.Trace().Msgf("[tls] open remote_addr=%s domain=%s", remote, domain)
+	log.Trace().Msgf("[tls] open remote_addr=%s domain=%s alpn=%s ja4=%s", remote, domain, strings.Join(ch.ALPN, ","), conn.JA4)
 
 	handler(src, domain, hello)
 
 	log.Trace().Msgf("[tls] close remote_addr=%s", remote)
 }
 
-func findHandler(domain string) handlerFunc {
-	domain = "." + domain
-	for k, handler := range handlers {
-		if strings.HasSuffix(domain, k) {
-			return handler
+// rule - all rule conditions should match, empty condition matches everything
+type rule struct {
+	domains []string
+	alpn    []string
+	ja3     []string
+	ja4     []string
+	clients []*net.IPNet
+	handler handlerFunc
+	quic    quicFunc // nil - drop QUIC, so clients use TCP
+}
+
+var rules []*rule
+var defaultHandler handlerFunc
+
+// ECH policy for the whole module: close connection or match rules by these domains instead of outer name
+var (
+	echBlock   bool
+	echDomains []string
+	echPublic  = []string{"cloudflare-ech.com"} // outer server names of ECH providers
+)
+
+// parseECH return ECH policy from config value: outer, block or list of hosts and domains
+func parseECH(s string) (block bool, domains []string, err error) {
+	switch s {
+	case "", "outer":
+		return false, nil, nil
+	case "block":
+		return true, nil, nil
+	}
+
+	domains, err = parseDomains(s)
+	return false, domains, err
+}
+
+// parseDomains return domains from list of hosts and domains
+func parseDomains(s string) ([]string, error) {
+	// unknown hosts name is left as is, so check that all values are domains
+	domains := hosts.Get(s)
+	for _, domain := range domains {
+		if !isDomain(domain) {
+			return nil, errors.New("unknown hosts or domain: " + domain)
 		}
 	}
+	return domains, nil
+}
+
+func isDomain(s string) bool {
+	if !strings.Contains(s, ".") || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// echMatch return domains for rules matching, false if connection should be closed by ECH policy
+func echMatch(hello *ClientHello) ([]string, bool) {
+	// with ECH real domain is encrypted and server name is the public outer name
+	if hello.ECH {
+		if echBlock {
+			return nil, false
+		}
+		if echDomains != nil {
+			return echDomains, true
+		}
+	}
+	return []string{hello.ServerName}, true
+}
+
+// isECHPublic check outer server name, browsers send GREASE ECH extension with any other names
+func isECHPublic(name string) bool {
+	for _, public := range echPublic {
+		if strings.EqualFold(name, public) {
+			return true
+		}
+	}
+	return false
+}
+
+// findHandler return handler of the first matching rule or default handler
+func findHandler(conn *Conn, domains []string) handlerFunc {
+	if r := findRule(conn, domains); r != nil {
+		return r.handler
+	}
 	return defaultHandler
 }
 
-func serve(address string) {
-	log.Info().Msgf("[tls] listen=%s", address)
+// findRule return the first matching rule or nil for default action, rule should match any of domains
+func findRule(conn *Conn, domains []string) *rule {
+	for _, r := range rules {
+		if r.domains != nil && !slices.ContainsFunc(domains, r.matchDomain) {
+			continue
+		}
+		if r.alpn != nil && !slices.ContainsFunc(conn.Hello.ALPN, func(s string) bool {
+			return slices.Contains(r.alpn, s)
+		}) {
+			continue
+		}
+		if r.ja3 != nil && !slices.Contains(r.ja3, conn.JA3) {
+			continue
+		}
+		if r.ja4 != nil && !slices.Contains(r.ja4, conn.JA4) {
+			continue
+		}
+		if r.clients != nil && !clients.Contains(r.clients, conn.RemoteAddr) {
+			continue
+		}
+		return r
+	}
 
-	ln, err := net.Listen("tcp", address)
-	if err != nil {
-		log.Error().Err(err).Caller().Send()
-		return
+	return nil
+}
+
+func (r *rule) matchDomain(domain string) bool {
+	domain = "." + domain
+	return slices.ContainsFunc(r.domains, func(s string) bool {
+		return strings.HasSuffix(domain, s)
+	})
+}
+
+// Covered check that domain is matched by domains of any rule
+func Covered(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	for _, r := range rules {
+		if r.domains != nil && r.matchDomain(domain) {
+			return true
+		}
 	}
+	return false
+}
+
+func serve(ln net.Listener) {
+	log.Info().Msgf("[tls] listen=%s", ln.Addr())
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Error().Err(err).Caller().Send()
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Caller().Send()
+				app.ModuleStatus("tls", err)
+			}
 			return
 		}
 		go Handle(conn)
 	}
 }
 
-func parseAction(raw string) handlerFunc {
-	if raw != "" {
-		action, params := app.ParseAction(raw)
-		switch action {
-		case "raw_pass":
-			return handleRaw(params)
-		case "proxy_pass":
-			return handleProxy(params)
-		case "split_pass":
-			return handleSplit(params)
-		}
-	}
-	return nil
+var actions = app.NewRegistry[handlerFunc]()
+
+func initActions() {
+	fragment := app.Param{Name: "fragment"}
+
+	app.Extend(actions, upstream.Actions, "raw_pass", []app.Param{
+		{Name: "host"}, {Name: "port"}, fragment, proxyProtocolParam,
+	}, handleRaw)
+	app.Extend(actions, upstream.Actions, "proxy_pass", []app.Param{
+		fragment,
+	}, handleProxy)
+	actions.Register("split_pass", slices.Concat(upstream.DirectParams, []app.Param{
+		{Name: "ttl"}, {Name: "strategy"}, fragment,
+	}), handleSplit)
+	actions.Register("auto", autoParams, handleAuto)
+	actions.Register("mitm", nil, handleMITM)
+	actions.Register("terminate", terminateParams, handleTerminate)
+	actions.Register("vhost_pass", vhostParams, handleVHost)
 }
 
-func handleRaw(params url.Values) handlerFunc {
-	forceHost := params.Get("host")
+func handleRaw(params url.Values, dialer upstream.Dialer) (handlerFunc, error) {
 	port := params.Get("port")
 	if port == "" {
 		port = "443"
 	}
 
-	dialer := net.Dialer{Timeout: 5 * time.Second}
+	return withFragment(withProxyHeader(handleDial(dialer, params.Get("host"), port), params), params)
+}
+
+var proxyProtocolParam = app.Param{Name: "proxy_protocol", Values: []string{"v1", "v2"}}
+
+// withProxyHeader send PROXY protocol header with client address before ClientHello
+func withProxyHeader(handler handlerFunc, params url.Values) handlerFunc {
+	version := params.Get("proxy_protocol")
+	if version == "" {
+		return handler
+	}
 
+	return func(src net.Conn, host string, hello []byte) {
+		header := proxyproto.Header(version, src.RemoteAddr(), src.LocalAddr())
+		handler(src, host, append(header, hello...))
+	}
+}
+
+func handleProxy(params url.Values, dialer upstream.Dialer) (handlerFunc, error) {
+	return withFragment(handleDial(dialer, "", "443"), params)
+}
+
+func handleDial(dialer upstream.Dialer, forceHost, port string) handlerFunc {
 	return func(src net.Conn, host string, hello []byte) {
 		if forceHost != "" {
 			host = forceHost
 		}
 
-		dst, err := dialer.Dial("tcp", host+":"+port)
+		dst, err := dialer.DialContext(clientContext(src), "tcp", net.JoinHostPort(host, port))
 		if err != nil {
 			log.Warn().Err(err).Caller().Send()
 			return
@@ -156,165 +432,149 @@ func handleRaw(params url.Values) handlerFunc {
 			return
 		}
 
-		go io.Copy(dst, src)
-		io.Copy(src, dst)
+		pipe(src, dst)
 	}
 }
 
-var splitRetry = map[string]byte{}
+// splitMemory - learned split level for hosts, level 0 is not saved
+var splitMemory *app.Memory[byte]
 
-func handleSplit(params url.Values) handlerFunc {
-	return func(src net.Conn, host string, hello []byte) {
-		for retry := splitRetry[host]; retry < 3; retry++ {
-			if err := handleSplitRetry(src, host, hello, retry); err == nil {
-				if retry > 0 {
-					log.Debug().Msgf("[tcp] split ok host=%s retry=%d", host, retry)
-					splitRetry[host] = retry
-				}
-				return
-			}
-		}
-		log.Warn().Msgf("[tcp] split fail host=%s", host)
-	}
-}
+const splitTTL = 24 * time.Hour
 
-func handleSplitRetry(src net.Conn, host string, hello []byte, retry byte) error {
-	dst, err := net.DialTimeout("tcp", host+":443", 5*time.Second)
+func handleSplit(params url.Values) (handlerFunc, error) {
+	split, err := newSplit(params)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer dst.Close()
 
-	k := 3 * time.Duration(retry) // 0, 3, 6
-
-	delay := k * time.Millisecond // 0ms, 3ms, 6ms
-	if err = writeSplit(dst, hello, delay); err != nil {
-		return err
-	}
+	return withFragment(func(src net.Conn, host string, hello []byte) {
+		if err := split(src, host, hello); err != nil {
+			log.Warn().Msgf("[tls] split fail host=%s", host)
+		}
+	}, params)
+}
 
-	timeout := 2*time.Second + k*time.Second // 2s, 5s, 8s
-	_ = dst.SetReadDeadline(time.Now().Add(timeout))
+// splitFunc - pass connection with split strategies, error if all strategies fail
+type splitFunc func(src net.Conn, host string, hello []byte) error
 
-	b, err := dst.Read(hello)
+// newSplit create retry ladder of split strategies, that starts from learned level of host
+func newSplit(params url.Values) (splitFunc, error) {
+	dialer, err := upstream.Direct(params)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_ = dst.SetReadDeadline(time.Time{})
-
-	if _, err = src.Write(hello[:b]); err != nil {
-		return nil
+	// hosts are probed from level 0 again after TTL
+	ttl := splitTTL
+	if s := params.Get("ttl"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+			return nil, errors.New("wrong value for param ttl: " + s)
+		}
 	}
 
-	go io.Copy(dst, src)
-	io.Copy(src, dst)
-
-	return nil
-}
+	// retry ladder, each next level has a longer timeout
+	names := params["strategy"]
+	if names == nil {
+		names = defaultLadder
+	}
 
-func writeSplit(conn net.Conn, hello []byte, delay time.Duration) error {
-	if delay == 0 {
-		for _, b := range hello {
-			if _, err := conn.Write([]byte{b}); err != nil {
-				return err
-			}
+	var ladder []*splitStrategy
+	for _, name := range names {
+		strategy, err := parseStrategy(name)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		t0 := time.Now()
-		for i, b := range hello {
-			if dt := t0.Add(time.Duration(i) * delay).Sub(time.Now()); dt > 0 {
-				time.Sleep(dt)
-			}
-			if _, err := conn.Write([]byte{b}); err != nil {
-				return err
-			}
+		// upstreams connection is not TCP connection to server, so TTL can't be changed
+		if strategy.tcp && params.Has("via") {
+			return nil, errors.New("strategy can't be used with via: " + name)
 		}
+		ladder = append(ladder, strategy)
 	}
-	return nil
-}
 
-func handleProxy(params url.Values) handlerFunc {
-	if !params.Has("host") || !params.Has("port") {
-		return nil
+	if splitMemory == nil {
+		splitMemory = app.NewMemory[byte]("tls.split")
 	}
 
-	if params.Get("type") == "socks5" {
-		return handleProxySOCKS5(params)
-	} else {
-		return handleProxyHTTP(params)
-	}
+	return func(src net.Conn, host string, hello []byte) (err error) {
+		level, _ := splitMemory.Get(host)
+		if int(level) >= len(ladder) {
+			level = 0 // ladder was changed in config
+		}
+		for retry := int(level); retry < len(ladder); retry++ {
+			if err = handleSplitRetry(dialer, src, host, hello, ladder[retry], retry); err == nil {
+				if retry != int(level) {
+					log.Debug().Msgf("[tls] split ok host=%s retry=%d strategy=%s", host, retry, ladder[retry].name)
+					splitMemory.Set(host, byte(retry), ttl)
+				}
+				return nil
+			}
+		}
+		splitMemory.Delete(host)
+		return err
+	}, nil
 }
 
-func handleProxyHTTP(params url.Values) handlerFunc {
-	address := params.Get("host") + ":" + params.Get("port")
-	connect := ":443 HTTP/1.1\r\n"
-	if params.Has("username") {
-		auth := base64.StdEncoding.EncodeToString(
-			[]byte(params.Get("username") + ":" + params.Get("password")),
-		)
-		connect += "Proxy-Authorization: Basic " + auth + "\r\n\r\n"
-	} else {
-		connect += "\r\n"
+func handleSplitRetry(dialer upstream.Dialer, src net.Conn, host string, hello []byte, strategy *splitStrategy, retry int) error {
+	write := func(dst net.Conn, hello []byte) error {
+		return strategy.send(dst, hello, host)
 	}
 
-	dialer := net.Dialer{Timeout: 5 * time.Second}
-
-	return func(src net.Conn, host string, hello []byte) {
-		dst, err := dialer.Dial("tcp", address)
-		if err != nil {
-			return
-		}
-		defer dst.Close()
+	timeout := 2*time.Second + 3*time.Duration(min(retry, 2))*time.Second // 2s, 5s, 8s
 
-		if _, err = dst.Write([]byte("CONNECT " + host + connect)); err != nil {
-			log.Warn().Err(err).Caller().Send()
-			return
-		}
+	dst, b, err := handshake(clientContext(src), dialer, host+":443", hello, write, timeout)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
 
-		b := make([]byte, 1024*4)
-		if _, err = dst.Read(b); err != nil {
-			return
-		}
+	if _, err = src.Write(b); err != nil {
+		return nil
+	}
 
-		if _, err = dst.Write(hello); err != nil {
-			log.Warn().Err(err).Caller().Send()
-			return
-		}
+	pipe(src, dst)
 
-		go io.Copy(dst, src)
-		io.Copy(src, dst)
-	}
+	return nil
 }
 
-func handleProxySOCKS5(params url.Values) handlerFunc {
-	address := params.Get("host") + ":" + params.Get("port")
+// handshake send ClientHello to new connection and wait first server response
+func handshake(
+	ctx context.Context, dialer upstream.Dialer, address string, hello []byte,
+	write func(dst net.Conn, hello []byte) error, timeout time.Duration,
+) (net.Conn, []byte, error) {
+	dst, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	var auth *proxy.Auth
-	if params.Has("username") {
-		auth = &proxy.Auth{
-			User:     params.Get("username"),
-			Password: params.Get("password"),
-		}
+	if err = write(dst, hello); err != nil {
+		_ = dst.Close()
+		return nil, nil, err
 	}
 
-	dialer, err := proxy.SOCKS5("tcp", address, auth, nil)
+	_ = dst.SetReadDeadline(time.Now().Add(timeout))
+
+	b := make([]byte, 4096)
+	n, err := dst.Read(b)
 	if err != nil {
-		return nil
+		_ = dst.Close()
+		return nil, nil, err
 	}
 
-	return func(src net.Conn, host string, hello []byte) {
-		dst, err := dialer.Dial("tcp", host+":443")
-		if err != nil {
-			return
-		}
-		defer dst.Close()
+	_ = dst.SetReadDeadline(time.Time{})
 
-		if _, err = dst.Write(hello); err != nil {
-			log.Warn().Err(err).Caller().Send()
-			return
-		}
+	return dst, b[:n], nil
+}
 
-		go io.Copy(dst, src)
-		io.Copy(src, dst)
-	}
+// pipe copy data in both directions and return when dst ends. The end of src closes dst,
+// so pipe returns too. The caller should close src, that stops the other direction.
+func pipe(src, dst net.Conn) {
+	go func() {
+		_, _ = io.Copy(dst, src)
+		_ = dst.Close()
+	}()
+	_, _ = io.Copy(src, dst)
+}
+
+func clientContext(src net.Conn) context.Context {
+	return upstream.WithClient(context.Background(), src.RemoteAddr().String())
 }
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package tls
+
+import (
+	"errors"
+	"net"
+)
+
+func setTTL(conn *net.TCPConn, ttl int) (int, error) {
+	return 0, errors.New("tls: disorder is not supported on this OS")
+}
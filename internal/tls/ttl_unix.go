@@ -0,0 +1,33 @@
+//go:build unix
+
+package tls
+
+import (
+	"net"
+	"syscall"
+)
+
+// setTTL change TTL (hop limit for IPv6) of connection and return previous value
+func setTTL(conn *net.TCPConn, ttl int) (prev int, err error) {
+	level, opt := syscall.IPPROTO_IP, syscall.IP_TTL
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	err2 := raw.Control(func(fd uintptr) {
+		if prev, err = syscall.GetsockoptInt(int(fd), level, opt); err != nil {
+			return
+		}
+		err = syscall.SetsockoptInt(int(fd), level, opt, ttl)
+	})
+	if err2 != nil {
+		return 0, err2
+	}
+
+	return prev, err
+}
@@ -0,0 +1,310 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+// quicFunc - dial UDP connection to server for QUIC flow
+type quicFunc func(host string) (net.Conn, error)
+
+var defaultQUIC quicFunc
+
+// newQUIC create QUIC forwarding for raw_pass action, other actions and upstreams can't forward UDP
+func newQUIC(action *app.Action) (quicFunc, error) {
+	if action.Name != "raw_pass" || action.Params.Has("via") {
+		return nil, nil
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	if name := action.Params.Get("interface"); name != "" {
+		direct, err := upstream.Direct(url.Values{"interface": {name}})
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := direct.(*net.Dialer); ok {
+			if addr, ok := d.LocalAddr.(*net.TCPAddr); ok {
+				dialer.LocalAddr = &net.UDPAddr{IP: addr.IP}
+			}
+		}
+	}
+
+	forceHost := action.Params.Get("host")
+	port := action.Params.Get("port")
+	if port == "" {
+		port = "443"
+	}
+
+	return func(host string) (net.Conn, error) {
+		if forceHost != "" {
+			host = forceHost
+		}
+		return dialer.Dial("udp", net.JoinHostPort(host, port))
+	}, nil
+}
+
+// quicSession - UDP flow from one client address
+type quicSession struct {
+	client net.Addr
+
+	mu      sync.Mutex // protects fields below, sessions mutex is only for table
+	dst     net.Conn   // nil until ClientHello is complete and server is dialed
+	opening bool
+	drop    bool
+	closed  bool     // removed from sessions table
+	packets [][]byte // packets before server is dialed
+	frames  []cryptoFrame
+	last    time.Time
+}
+
+// quicSessions - NAT-style session table by client address
+var (
+	quicSessions   = map[string]*quicSession{}
+	quicSessionsMu sync.Mutex
+)
+
+// quicMaxPackets - ClientHello usually takes one or two packets
+const quicMaxPackets = 16
+
+func initQUIC(address string, timeout time.Duration) {
+	var ln net.PacketConn
+	app.OnStart("quic", func() error {
+		var err error
+		if ln, err = net.ListenPacket("udp", address); err != nil {
+			return err
+		}
+		go serveQUIC(ln, timeout)
+		return nil
+	})
+	app.OnStop("quic", func(context.Context) error {
+		if ln != nil {
+			_ = ln.Close()
+		}
+		closeQUICSessions(0)
+		return nil
+	})
+}
+
+func serveQUIC(ln net.PacketConn, timeout time.Duration) {
+	log.Info().Msgf("[tls] quic listen=%s", ln.LocalAddr())
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				closeQUICSessions(timeout)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	b := make([]byte, 0xFFFF)
+	for {
+		n, addr, err := ln.ReadFrom(b)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Caller().Send()
+				app.ModuleStatus("quic", err)
+			}
+			return
+		}
+		handleQUIC(ln, addr, slices.Clone(b[:n]))
+	}
+}
+
+func handleQUIC(ln net.PacketConn, addr net.Addr, b []byte) {
+	key := addr.String()
+
+	quicSessionsMu.Lock()
+	s := quicSessions[key]
+	if s == nil && isQUICInitial(b) {
+		s = &quicSession{client: addr}
+		quicSessions[key] = s
+	}
+	quicSessionsMu.Unlock()
+
+	// unknown flow without Initial packet, ex. after restart
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.last = time.Now()
+
+	if dst := s.dst; dst != nil {
+		s.mu.Unlock()
+		_, _ = dst.Write(b)
+		return
+	}
+
+	defer s.mu.Unlock()
+
+	switch {
+	case s.drop:
+		return
+	case len(s.packets) >= quicMaxPackets:
+		log.Debug().Msgf("[tls] quic drop remote_addr=%s: too many packets", key)
+		s.drop, s.packets, s.frames = true, nil, nil
+		return
+	}
+
+	s.packets = append(s.packets, b)
+
+	if s.opening {
+		return
+	}
+
+	frames, err := parseQUICInitial(b)
+	if err != nil {
+		log.Trace().Err(err).Msgf("[tls] quic skip remote_addr=%s", key)
+		return
+	}
+
+	s.frames = append(s.frames, frames...)
+
+	if hello := cryptoHello(s.frames); hello != nil {
+		s.opening, s.frames = true, nil
+		go openQUIC(ln, s, hello)
+	}
+}
+
+// openQUIC apply rules to ClientHello and dial server or drop flow, so client falls back to TCP
+func openQUIC(ln net.PacketConn, s *quicSession, hello []byte) {
+	remote := s.client.String()
+
+	dst, err := dialQUIC(remote, hello)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		log.Debug().Err(err).Msgf("[tls] quic drop remote_addr=%s", remote)
+		s.drop, s.packets = true, nil
+		return
+	}
+
+	if s.closed {
+		_ = dst.Close() // session was closed while dialing
+		return
+	}
+
+	s.dst = dst
+	for _, b := range s.packets {
+		_, _ = dst.Write(b)
+	}
+	s.packets = nil
+
+	go pipeQUIC(ln, s)
+}
+
+func dialQUIC(remote string, hello []byte) (net.Conn, error) {
+	ch, err := ParseClientHello(hello)
+	if err != nil {
+		return nil, err
+	}
+
+	if ch.ServerName == "" {
+		return nil, errors.New("quic: empty domain")
+	}
+
+	ch.QUIC = true
+
+	match, ok := echMatch(ch)
+	if !ok {
+		return nil, errors.New("quic: block ech domain=" + ch.ServerName)
+	}
+
+	conn := &Conn{RemoteAddr: remote, Hello: ch, JA3: ch.JA3(), JA4: ch.JA4()}
+
+	dial := defaultQUIC
+	if r := findRule(conn, match); r != nil {
+		dial = r.quic
+	}
+	if dial == nil {
+		return nil, errors.New("quic: action can't forward UDP domain=" + ch.ServerName)
+	}
+
+	log.Trace().Msgf("[tls] quic open remote_addr=%s domain=%s", remote, ch.ServerName)
+
+	return dial(ch.ServerName)
+}
+
+// pipeQUIC copy packets from server to client until session is closed
+func pipeQUIC(ln net.PacketConn, s *quicSession) {
+	b := make([]byte, 0xFFFF)
+	for {
+		n, err := s.dst.Read(b)
+		if err != nil {
+			break
+		}
+		if _, err = ln.WriteTo(b[:n], s.client); err != nil {
+			break
+		}
+
+		s.mu.Lock()
+		s.last = time.Now()
+		s.mu.Unlock()
+	}
+
+	quicSessionsMu.Lock()
+	if key := s.client.String(); quicSessions[key] == s {
+		delete(quicSessions, key)
+	}
+	quicSessionsMu.Unlock()
+
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	_ = s.dst.Close()
+
+	log.Trace().Msgf("[tls] quic close remote_addr=%s", s.client)
+}
+
+// closeQUICSessions close sessions without packets for timeout, all sessions for zero timeout
+func closeQUICSessions(timeout time.Duration) {
+	var closed []*quicSession
+
+	quicSessionsMu.Lock()
+	now := time.Now()
+	for key, s := range quicSessions {
+		s.mu.Lock()
+		idle := now.Sub(s.last)
+		s.mu.Unlock()
+
+		if timeout != 0 && idle < timeout {
+			continue
+		}
+		delete(quicSessions, key)
+		closed = append(closed, s)
+	}
+	quicSessionsMu.Unlock()
+
+	// connections are closed without sessions mutex
+	for _, s := range closed {
+		s.mu.Lock()
+		s.closed = true
+		dst := s.dst
+		s.mu.Unlock()
+
+		if dst != nil {
+			_ = dst.Close()
+		}
+	}
+}
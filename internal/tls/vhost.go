@@ -0,0 +1,120 @@
+package tls
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+var vhostParams = slices.Concat(upstream.DirectParams, []app.Param{
+	{Name: "backend", Required: true}, // pattern=target, ex. {1}.home.example={1}.lan:443
+	proxyProtocolParam,
+})
+
+// vhostBackend - domain pattern with {N} labels and target address with captured labels
+type vhostBackend struct {
+	labels []string
+	target string
+}
+
+var (
+	vhostLabel   = regexp.MustCompile(`\{\d}`)
+	vhostCapture = regexp.MustCompile(`^\{\d}$`)
+	hostLabel    = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+)
+
+// handleVHost pass connection without decryption to backend address by server name
+func handleVHost(params url.Values) (handlerFunc, error) {
+	dialer, err := upstream.Direct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var backends []*vhostBackend
+	for _, s := range params["backend"] {
+		backend, err := parseBackend(s)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+
+	return withProxyHeader(func(src net.Conn, host string, hello []byte) {
+		var address string
+		for _, backend := range backends {
+			if address = backend.match(host); address != "" {
+				break
+			}
+		}
+
+		if address == "" {
+			log.Debug().Msgf("[tls] vhost no backend domain=%s", host)
+			return
+		}
+
+		dst, err := dialer.DialContext(clientContext(src), "tcp", address)
+		if err != nil {
+			log.Warn().Err(err).Caller().Send()
+			return
+		}
+		defer dst.Close()
+
+		if _, err = dst.Write(hello); err != nil {
+			log.Warn().Err(err).Caller().Send()
+			return
+		}
+
+		pipe(src, dst)
+	}, params), nil
+}
+
+func parseBackend(s string) (*vhostBackend, error) {
+	pattern, target, ok := strings.Cut(s, "=")
+	if !ok || pattern == "" || target == "" {
+		return nil, errors.New("wrong backend: " + s)
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "443")
+	}
+
+	backend := &vhostBackend{labels: strings.Split(pattern, "."), target: target}
+
+	// all labels in target should be captured by pattern
+	for _, m := range vhostLabel.FindAllString(target, -1) {
+		if !slices.Contains(backend.labels, m) {
+			return nil, errors.New("wrong backend: " + s + ": no label " + m + " in pattern")
+		}
+	}
+
+	return backend, nil
+}
+
+// match return target address for domain or empty string
+func (b *vhostBackend) match(domain string) string {
+	labels := strings.Split(domain, ".")
+	if len(labels) != len(b.labels) {
+		return ""
+	}
+
+	target := b.target
+	for i, label := range b.labels {
+		if vhostCapture.MatchString(label) {
+			// don't allow ports and other special chars from client in target address
+			if !hostLabel.MatchString(labels[i]) {
+				return ""
+			}
+			target = strings.ReplaceAll(target, label, labels[i])
+		} else if !strings.EqualFold(label, labels[i]) {
+			return ""
+		}
+	}
+	return target
+}
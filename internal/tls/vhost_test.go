@@ -0,0 +1,25 @@
+package tls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVHostBackend(t *testing.T) {
+	_, err := parseBackend("nas.home.example")
+	require.NotNil(t, err)
+	_, err = parseBackend("{1}.home.example={2}.lan")
+	require.NotNil(t, err)
+
+	nas, err := parseBackend("nas.home.example=192.168.1.10:5001")
+	require.Nil(t, err)
+	require.Equal(t, "192.168.1.10:5001", nas.match("NAS.home.example"))
+	require.Equal(t, "", nas.match("ha.home.example"))
+
+	wildcard, err := parseBackend("{1}.{2}.home.example={2}-{1}.lan")
+	require.Nil(t, err)
+	require.Equal(t, "b-a.lan:443", wildcard.match("a.b.home.example"))
+	require.Equal(t, "", wildcard.match("b.home.example"))
+	require.Equal(t, "", wildcard.match("a:22.b.home.example"))
+}
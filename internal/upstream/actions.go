@@ -0,0 +1,13 @@
+package upstream
+
+import "github.com/AlexxIT/pnproxy/internal/app"
+
+// Actions - outbound actions shared by all modules, modules extend them with own params and handlers
+var Actions = newActions()
+
+func newActions() *app.Registry[Dialer] {
+	r := app.NewRegistry[Dialer]()
+	r.Register("raw_pass", DirectParams, Direct)
+	r.Register("proxy_pass", ProxyParams, Proxy)
+	return r
+}
@@ -0,0 +1,180 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/rs/zerolog/log"
+)
+
+// checkParams - health check params for all upstream types
+var checkParams = []app.Param{
+	{Name: "check", Values: []string{"tcp", "connect", "https"}},
+	{Name: "check_host"},
+	{Name: "check_interval"},
+}
+
+const (
+	checkHost     = "www.google.com"
+	checkInterval = 30 * time.Second
+	checkTimeout  = 2 * dialTimeout
+)
+
+type check struct {
+	kind     string
+	host     string
+	interval time.Duration
+}
+
+func newCheck(params url.Values) (*check, error) {
+	if !params.Has("check") {
+		return nil, nil
+	}
+
+	c := &check{kind: params.Get("check"), host: params.Get("check_host"), interval: checkInterval}
+	if c.host == "" {
+		c.host = checkHost
+	}
+
+	if s := params.Get("check_interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("wrong value for param check_interval: %q", s)
+		}
+		c.interval = d
+	}
+
+	return c, nil
+}
+
+func initChecks() {
+	var checked []*Upstream
+	for _, u := range upstreams {
+		if u.check != nil {
+			checked = append(checked, u)
+		}
+	}
+
+	if checked == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	app.OnStart("upstream", func() error {
+		for _, u := range checked {
+			go u.checkLoop(ctx)
+		}
+		return nil
+	})
+	app.OnStop("upstream", func(context.Context) error {
+		cancel()
+		return nil
+	})
+}
+
+func (u *Upstream) checkLoop(ctx context.Context) {
+	ticker := time.NewTicker(u.check.interval)
+	defer ticker.Stop()
+
+	for {
+		err := u.runCheck(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Trace().Err(err).Msgf("[upstream] %s check=%s", u.Name, u.check.kind)
+		u.reportCheck(err)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runCheck - tcp: connect to proxy server, connect: open tunnel to check host, https: GET request to check host
+func (u *Upstream) runCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	address := u.check.host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+
+	switch u.check.kind {
+	case "tcp":
+		dialer, target := u.dialer, address
+		if u.forward != nil {
+			dialer, target = u.forward, u.Address
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case "connect":
+		conn, err := u.dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case "https":
+		transport := &http.Transport{DialContext: u.dialer.DialContext}
+		defer transport.CloseIdleConnections()
+
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://"+u.check.host+"/", nil)
+		if err != nil {
+			return err
+		}
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	return nil
+}
+
+// Fallback - upstreams tried in order, upstreams in down state are tried last
+type Fallback []*Upstream
+
+func (f Fallback) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	down := make([]bool, len(f))
+	for i, u := range f {
+		down[i] = u.Down()
+	}
+
+	var errs []error
+	for _, pass := range []bool{false, true} {
+		for i, u := range f {
+			if down[i] != pass {
+				continue
+			}
+
+			conn, err := u.DialContext(ctx, network, address)
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+
+			log.Debug().Err(err).Msgf("[upstream] fallback from %s address=%s", u.Name, address)
+			errs = append(errs, fmt.Errorf("%s: %w", u.Name, err))
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
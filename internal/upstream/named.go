@@ -0,0 +1,341 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+func Init() {
+	var cfg struct {
+		Upstreams map[string]definition `yaml:"upstreams"`
+	}
+
+	app.LoadConfig(&cfg)
+
+	initTypes()
+
+	createUpstreams(cfg.Upstreams)
+
+	initChecks()
+}
+
+// createUpstreams create upstreams in dependency order, chains and pools use upstreams from via params
+func createUpstreams(defs map[string]definition) {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	done := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var create func(name string)
+	create = func(name string) {
+		if done[name] {
+			return
+		}
+
+		action := defs[name].Action
+
+		if visiting[name] {
+			app.ConfigError(action.Errorf("[upstream] %s: via loop", name))
+			return
+		}
+
+		visiting[name] = true
+		for _, via := range action.Params["via"] {
+			if _, ok := defs[via]; ok {
+				create(via)
+			}
+		}
+		visiting[name] = false
+		done[name] = true
+
+		u, err := types.New(&action)
+		if err != nil {
+			app.ConfigError(action.Errorf("[upstream] %s: %w", name, err))
+			return
+		}
+		if u.check, err = newCheck(action.Params); err != nil {
+			app.ConfigError(action.Errorf("[upstream] %s: %w", name, err))
+			return
+		}
+		u.Name = name
+		u.Type = action.Name
+		upstreams[name] = u
+	}
+
+	for _, name := range names {
+		create(name)
+	}
+}
+
+// definition - upstream from config, structured form has upstream type in "type" key
+type definition struct {
+	app.Action
+}
+
+func (d *definition) UnmarshalYAML(node *yaml.Node) error {
+	return d.Action.UnmarshalNode(node, "type")
+}
+
+// Get return named upstream from config, "direct" name can be used without config
+func Get(name string) (*Upstream, error) {
+	if u, ok := upstreams[name]; ok {
+		return u, nil
+	}
+	if name == "direct" {
+		return &Upstream{Name: name, Type: name, dialer: &net.Dialer{Timeout: dialTimeout}}, nil
+	}
+	return nil, errors.New("unknown upstream: " + name)
+}
+
+// getAll return one named upstream or fallback list of upstreams
+func getAll(names []string) (Dialer, error) {
+	if len(names) == 1 {
+		return Get(names[0])
+	}
+
+	fallback := make(Fallback, len(names))
+	for i, name := range names {
+		u, err := Get(name)
+		if err != nil {
+			return nil, err
+		}
+		fallback[i] = u
+	}
+	return fallback, nil
+}
+
+// Statuses return copy of all named upstreams statuses
+func Statuses() map[string]Status {
+	statuses := make(map[string]Status, len(upstreams))
+	for name, u := range upstreams {
+		statuses[name] = u.Status()
+	}
+	return statuses
+}
+
+// HTTPProxy return proxy URL and dialer to the proxy, if dialer is HTTP proxy
+func HTTPProxy(dialer Dialer) (*url.URL, Dialer, bool) {
+	var u *Upstream
+	if u, _ = dialer.(*Upstream); u != nil {
+		dialer = u.dialer
+	}
+
+	d, ok := dialer.(*httpDialer)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if u != nil {
+		return d.ProxyURL(), &trackDialer{u: u, dialer: d.forward}, true
+	}
+
+	return d.ProxyURL(), d.forward, true
+}
+
+const (
+	StateUnknown = "unknown"
+	StateUp      = "up"
+	StateDown    = "down"
+)
+
+// Status - named upstream state from the last dial or health check
+type Status struct {
+	Type    string     `json:"type"`
+	Address string     `json:"address,omitempty"`
+	State   string     `json:"state"`
+	Error   string     `json:"error,omitempty"`
+	Since   time.Time  `json:"since"`
+	Dials   int        `json:"dials"`
+	Fails   int        `json:"fails"`
+	Check   string     `json:"check,omitempty"`
+	Checked *time.Time `json:"checked,omitempty"`
+}
+
+// Upstream - named outbound route from config
+type Upstream struct {
+	Name    string
+	Type    string
+	Address string
+
+	dialer Dialer
+	check  *check
+
+	// for chains and health checks
+	proxy   proxyFunc
+	user    *url.Userinfo
+	forward Dialer
+
+	mu     sync.Mutex
+	status Status
+}
+
+func (u *Upstream) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := u.dialer.DialContext(ctx, network, address)
+	u.report(err)
+	return conn, err
+}
+
+func (u *Upstream) Status() Status {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	status := u.status
+	status.Type = u.Type
+	status.Address = u.Address
+	if u.check != nil {
+		status.Check = u.check.kind
+	}
+	if status.State == "" {
+		status.State = StateUnknown
+	}
+	return status
+}
+
+func (u *Upstream) Down() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status.State == StateDown
+}
+
+func (u *Upstream) report(err error) {
+	// ignore canceled requests, they say nothing about upstream
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.status.Dials++
+	if err != nil {
+		u.status.Fails++
+	}
+
+	u.setState(err)
+}
+
+func (u *Upstream) reportCheck(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	u.status.Checked = &now
+
+	u.setState(err)
+}
+
+func (u *Upstream) setState(err error) {
+	state := StateUp
+	if err != nil {
+		state = StateDown
+		u.status.Error = err.Error()
+	} else {
+		u.status.Error = ""
+	}
+
+	if u.status.State != state {
+		if err != nil {
+			log.Warn().Err(err).Msgf("[upstream] %s state=%s", u.Name, state)
+		} else {
+			log.Info().Msgf("[upstream] %s state=%s", u.Name, state)
+		}
+		u.status.State = state
+		u.status.Since = time.Now()
+	}
+}
+
+// trackDialer - dialer to proxy server with named upstream status
+type trackDialer struct {
+	u      *Upstream
+	dialer Dialer
+}
+
+func (d *trackDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	d.u.report(err)
+	return conn, err
+}
+
+var upstreams = map[string]*Upstream{}
+
+var types *app.Registry[*Upstream]
+
+func initTypes() {
+	types = app.NewRegistry[*Upstream]()
+
+	types.Register("direct", append([]app.Param{{Name: "interface"}}, checkParams...), func(params url.Values) (*Upstream, error) {
+		dialer, err := Direct(params)
+		if err != nil {
+			return nil, err
+		}
+		return &Upstream{dialer: dialer}, nil
+	})
+
+	proxyParams := []app.Param{
+		{Name: "host", Required: true},
+		{Name: "port", Required: true},
+		{Name: "username"},
+		{Name: "password"},
+		{Name: "interface"},
+	}
+	proxyParams = append(proxyParams, checkParams...)
+
+	for scheme, fn := range proxies {
+		types.Register(scheme, proxyParams, func(params url.Values) (*Upstream, error) {
+			forward, err := Direct(params)
+			if err != nil {
+				return nil, err
+			}
+
+			address, user := proxyAddress(params), proxyUser(params)
+			dialer, err := fn(address, user, forward)
+			if err != nil {
+				return nil, err
+			}
+			return &Upstream{Address: address, dialer: dialer, proxy: fn, user: user, forward: forward}, nil
+		})
+	}
+
+	types.Register("chain", append([]app.Param{{Name: "via", Required: true}}, checkParams...), newChain)
+	types.Register("pool", poolParams, newPool)
+}
+
+// newChain connect to the first upstream and then through each next proxy
+func newChain(params url.Values) (*Upstream, error) {
+	var dialer Dialer
+
+	for i, name := range params["via"] {
+		u, err := Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			dialer = u
+			continue
+		}
+
+		if u.proxy == nil {
+			return nil, errors.New("not a proxy upstream: " + name)
+		}
+
+		if dialer, err = u.proxy(u.Address, u.user, &trackDialer{u: u, dialer: dialer}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Upstream{dialer: dialer}, nil
+}
@@ -0,0 +1,227 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/rs/zerolog/log"
+)
+
+// poolParams - params for pool upstream type
+var poolParams = []app.Param{
+	{Name: "via", Required: true},
+	{Name: "strategy", Values: []string{"round_robin", "least_conn", "random", "hash_host", "hash_client"}},
+	{Name: "max_fails"},
+	{Name: "fail_timeout"},
+}
+
+const (
+	poolMaxFails    = 3
+	poolFailTimeout = 30 * time.Second
+)
+
+type clientKey struct{}
+
+// WithClient save client address to context for hash_client pool strategy
+func WithClient(ctx context.Context, address string) context.Context {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return context.WithValue(ctx, clientKey{}, address)
+}
+
+type pool struct {
+	u           *Upstream
+	strategy    string
+	maxFails    int
+	failTimeout time.Duration
+
+	members []*poolMember
+	next    atomic.Uint32
+}
+
+type poolMember struct {
+	u     *Upstream
+	conns atomic.Int32
+
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+func newPool(params url.Values) (*Upstream, error) {
+	p := &pool{
+		strategy:    params.Get("strategy"),
+		maxFails:    poolMaxFails,
+		failTimeout: poolFailTimeout,
+	}
+
+	if p.strategy == "" {
+		p.strategy = "round_robin"
+	}
+
+	if s := params.Get("max_fails"); s != "" {
+		i, err := strconv.Atoi(s)
+		if err != nil || i <= 0 {
+			return nil, fmt.Errorf("wrong value for param max_fails: %q", s)
+		}
+		p.maxFails = i
+	}
+
+	if s := params.Get("fail_timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("wrong value for param fail_timeout: %q", s)
+		}
+		p.failTimeout = d
+	}
+
+	for _, name := range params["via"] {
+		u, err := Get(name)
+		if err != nil {
+			return nil, err
+		}
+		p.members = append(p.members, &poolMember{u: u})
+	}
+
+	p.u = &Upstream{dialer: p}
+	return p.u, nil
+}
+
+func (p *pool) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var errs []error
+
+	for _, m := range p.order(ctx, address) {
+		conn, err := m.u.DialContext(ctx, network, address)
+		if err == nil {
+			m.success()
+			m.conns.Add(1)
+			return &poolConn{Conn: conn, m: m}, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		if m.fail(p.maxFails, p.failTimeout) {
+			log.Warn().Msgf("[upstream] %s mark down member=%s for=%s", p.u.Name, m.u.Name, p.failTimeout)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.u.Name, err))
+	}
+
+	if errs == nil {
+		return nil, errors.New("upstream: no available pool members")
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// order return available members in strategy order, all members if nothing available
+func (p *pool) order(ctx context.Context, address string) []*poolMember {
+	now := time.Now()
+
+	members := make([]*poolMember, 0, len(p.members))
+	for _, m := range p.members {
+		if m.available(now) {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		members = append(members, p.members...)
+	}
+
+	switch p.strategy {
+	case "round_robin":
+		i := int((p.next.Add(1) - 1) % uint32(len(members)))
+		members = append(members[i:], members[:i]...)
+	case "least_conn":
+		sort.SliceStable(members, func(i, j int) bool {
+			return members[i].conns.Load() < members[j].conns.Load()
+		})
+	case "random":
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+	case "hash_host":
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		sortByHash(members, host)
+	case "hash_client":
+		client, _ := ctx.Value(clientKey{}).(string)
+		sortByHash(members, client)
+	}
+
+	return members
+}
+
+// sortByHash - rendezvous hashing, so only keys of removed member move to other members
+func sortByHash(members []*poolMember, key string) {
+	weights := make(map[*poolMember]uint64, len(members))
+	for _, m := range members {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte(m.u.Name))
+		weights[m] = h.Sum64()
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		return weights[members[i]] > weights[members[j]]
+	})
+}
+
+func (m *poolMember) available(now time.Time) bool {
+	// member with health check is down until next successful check
+	if m.u.check != nil && m.u.Down() {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return now.After(m.downUntil)
+}
+
+func (m *poolMember) success() {
+	m.mu.Lock()
+	m.fails = 0
+	m.mu.Unlock()
+}
+
+// fail return true if member was marked down
+func (m *poolMember) fail(maxFails int, timeout time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fails++
+	if m.fails < maxFails {
+		return false
+	}
+
+	m.fails = 0
+	m.downUntil = time.Now().Add(timeout)
+	return true
+}
+
+type poolConn struct {
+	net.Conn
+	m    *poolMember
+	once sync.Once
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(func() {
+		c.m.conns.Add(-1)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	echo := testEcho(t)
+
+	// closed port for dead proxy
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	_ = ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	initTypes()
+
+	for _, name := range []string{"dead", "direct"} {
+		action := &app.Action{Name: "http", Params: url.Values{"host": {host}, "port": {port}}}
+		if name == "direct" {
+			action = &app.Action{Name: "direct"}
+		}
+		u, err := types.New(action)
+		require.Nil(t, err)
+		u.Name = name
+		upstreams[name] = u
+	}
+
+	u, err := types.New(&app.Action{Name: "pool", Params: url.Values{
+		"via": {"dead", "direct"}, "max_fails": {"2"},
+	}})
+	require.Nil(t, err)
+	p := u.dialer.(*pool)
+
+	// round robin with failover to next member
+	for i := 0; i < 4; i++ {
+		testDial(t, u, echo)
+	}
+	require.Equal(t, 2, upstreams["dead"].Status().Fails)
+
+	// dead member marked down after two fails
+	members := p.order(context.Background(), echo)
+	require.Len(t, members, 1)
+	require.Equal(t, "direct", members[0].u.Name)
+
+	// counter overflow on 32-bit platforms
+	p.next.Store(math.MaxUint32)
+	require.Len(t, p.order(context.Background(), echo), 1)
+	require.Len(t, p.order(context.Background(), echo), 1)
+
+	// same host always has same order
+	p.strategy = "hash_host"
+	m1 := sortedNames(p.members, "example.com")
+	for i := 0; i < 10; i++ {
+		require.Equal(t, m1, sortedNames(p.members, "example.com"))
+	}
+}
+
+func sortedNames(members []*poolMember, key string) (names []string) {
+	members = append([]*poolMember(nil), members...)
+	sortByHash(members, key)
+	for _, m := range members {
+		names = append(names, m.u.Name)
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+type httpDialer struct {
+	address string
+	user    *url.Userinfo
+	forward Dialer
+}
+
+func newHTTP(address string, user *url.Userinfo, forward Dialer) (Dialer, error) {
+	return &httpDialer{address: address, user: user, forward: forward}, nil
+}
+
+// ProxyURL - HTTP module can send plain HTTP requests to this proxy without CONNECT
+func (d *httpDialer) ProxyURL() *url.URL {
+	return &url.URL{Scheme: "http", Host: d.address, User: d.user}
+}
+
+func (d *httpDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.address)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dialTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	connect := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if d.user != nil {
+		password, _ := d.user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(d.user.Username() + ":" + password))
+		connect += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	connect += "\r\n"
+
+	if _, err = conn.Write([]byte(connect)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	rd := bufio.NewReader(conn)
+	res, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.New("upstream: proxy response: " + res.Status)
+	}
+
+	if rd.Buffered() > 0 {
+		return &bufConn{Conn: conn, rd: rd}, nil
+	}
+
+	return conn, nil
+}
+
+type bufConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+func (c *bufConn) Read(b []byte) (int, error) {
+	return c.rd.Read(b)
+}
+
+type socks5Dialer struct {
+	dialer proxy.Dialer
+}
+
+func newSOCKS5(address string, user *url.Userinfo, forward Dialer) (Dialer, error) {
+	var auth *proxy.Auth
+	if user != nil {
+		auth = &proxy.Auth{User: user.Username()}
+		auth.Password, _ = user.Password()
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", address, auth, forwardDialer{forward})
+	if err != nil {
+		return nil, err
+	}
+
+	return &socks5Dialer{dialer: dialer}, nil
+}
+
+func (d *socks5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.dialer.(proxy.ContextDialer).DialContext(ctx, network, address)
+}
+
+// forwardDialer - adapter for golang.org/x/net/proxy package
+type forwardDialer struct {
+	Dialer
+}
+
+func (d forwardDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
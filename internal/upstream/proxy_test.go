@@ -0,0 +1,169 @@
+package upstream
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	echo := testEcho(t)
+	host, port := testProxy(t)
+
+	dialer, err := Proxy(url.Values{
+		"host": {host}, "port": {port}, "username": {"user1"}, "password": {"pasw1"},
+	})
+	require.Nil(t, err)
+
+	testDial(t, dialer, echo)
+
+	dialer, err = Proxy(url.Values{"host": {host}, "port": {port}})
+	require.Nil(t, err)
+
+	_, err = dialer.DialContext(context.Background(), "tcp", echo)
+	require.NotNil(t, err)
+}
+
+func TestChain(t *testing.T) {
+	echo := testEcho(t)
+	host, port := testProxy(t)
+
+	initTypes()
+
+	for _, name := range []string{"proxy1", "proxy2"} {
+		u, err := types.New(&app.Action{Name: "http", Params: url.Values{
+			"host": {host}, "port": {port}, "username": {"user1"}, "password": {"pasw1"},
+		}})
+		require.Nil(t, err)
+		u.Name = name
+		upstreams[name] = u
+	}
+
+	u, err := types.New(&app.Action{Name: "chain", Params: url.Values{"via": {"proxy1", "proxy2"}}})
+	require.Nil(t, err)
+
+	testDial(t, u, echo)
+	require.Equal(t, StateUp, u.Status().State)
+	require.Equal(t, 1, upstreams["proxy2"].Status().Dials)
+
+	dialer, err := Proxy(url.Values{"via": {"proxy1"}})
+	require.Nil(t, err)
+
+	testDial(t, dialer, echo)
+	require.Equal(t, 2, upstreams["proxy1"].Status().Dials)
+
+	_, err = Proxy(url.Values{"via": {"proxy3"}})
+	require.NotNil(t, err)
+}
+
+func TestFallback(t *testing.T) {
+	echo := testEcho(t)
+
+	// closed port for dead proxy
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	_ = ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	initTypes()
+
+	u, err := types.New(&app.Action{Name: "http", Params: url.Values{"host": {host}, "port": {port}}})
+	require.Nil(t, err)
+	u.Name = "dead"
+	u.check, err = newCheck(url.Values{"check": {"tcp"}})
+	require.Nil(t, err)
+	upstreams["dead"] = u
+
+	require.NotNil(t, u.runCheck(context.Background()))
+
+	dialer, err := Proxy(url.Values{"via": {"dead", "direct"}})
+	require.Nil(t, err)
+
+	testDial(t, dialer, echo)
+	require.True(t, u.Down())
+}
+
+func testDial(t *testing.T, dialer Dialer, address string) {
+	conn, err := dialer.DialContext(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.Nil(t, err)
+
+	b := make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(b))
+}
+
+func testEcho(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// testProxy - CONNECT proxy with auth
+func testProxy(t *testing.T) (host, port string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjE6cGFzdzE=" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		src, _, _ := w.(http.Hijacker).Hijack()
+		_, _ = src.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(dst, src)
+		_, _ = io.Copy(src, dst)
+	}))
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return
+}
+
+func TestCreateUpstreams(t *testing.T) {
+	echo := testEcho(t)
+
+	initTypes()
+
+	// chain "a" is created after pool "b", though it goes first by name and type
+	createUpstreams(map[string]definition{
+		"a": {app.Action{Name: "chain", Params: url.Values{"via": {"b"}}}},
+		"b": {app.Action{Name: "pool", Params: url.Values{"via": {"direct"}}}},
+		"c": {app.Action{Name: "chain", Params: url.Values{"via": {"d"}}}},
+		"d": {app.Action{Name: "chain", Params: url.Values{"via": {"c"}}}},
+	})
+
+	u, err := Get("a")
+	require.Nil(t, err)
+	testDial(t, u, echo)
+
+	_, err = Get("c")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,135 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/AlexxIT/pnproxy/internal/app"
+)
+
+// Dialer - outbound route for TCP connections
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DirectParams - params for direct connections from any module
+var DirectParams = []app.Param{
+	{Name: "interface"},
+	{Name: "via"}, // names from upstreams, tried in order
+}
+
+// ProxyParams - params for proxy connections from any module
+var ProxyParams = []app.Param{
+	{Name: "type"},
+	{Name: "host"},
+	{Name: "port"},
+	{Name: "username"},
+	{Name: "password"},
+	{Name: "interface"},
+	{Name: "via"}, // names from upstreams, tried in order
+}
+
+type proxyFunc func(address string, user *url.Userinfo, forward Dialer) (Dialer, error)
+
+// proxies - all proxy types for proxy_pass action and upstreams
+var proxies = map[string]proxyFunc{
+	"http":   newHTTP,
+	"socks5": newSOCKS5,
+}
+
+const dialTimeout = 5 * time.Second
+
+// Direct create dialer from named upstream or direct dialer with optional network interface
+func Direct(params url.Values) (Dialer, error) {
+	if params.Has("via") {
+		return getAll(params["via"])
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	if name := params.Get("interface"); name != "" {
+		addr, err := interfaceAddr(name)
+		if err != nil {
+			return nil, err
+		}
+		dialer.LocalAddr = addr
+	}
+
+	return dialer, nil
+}
+
+// Proxy create dialer from named upstream or proxy from params
+func Proxy(params url.Values) (Dialer, error) {
+	if params.Has("via") {
+		return getAll(params["via"])
+	}
+
+	if !params.Has("host") || !params.Has("port") {
+		return nil, errors.New("missing param: host and port or via")
+	}
+
+	fn, err := proxyType(params)
+	if err != nil {
+		return nil, err
+	}
+
+	forward, err := Direct(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return fn(proxyAddress(params), proxyUser(params), forward)
+}
+
+func proxyType(params url.Values) (proxyFunc, error) {
+	scheme := params.Get("type")
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	fn, ok := proxies[scheme]
+	if !ok {
+		return nil, errors.New("wrong type: " + scheme)
+	}
+
+	return fn, nil
+}
+
+func proxyAddress(params url.Values) string {
+	return net.JoinHostPort(params.Get("host"), params.Get("port"))
+}
+
+func proxyUser(params url.Values) *url.Userinfo {
+	if !params.Has("username") {
+		return nil
+	}
+	if params.Has("password") {
+		return url.UserPassword(params.Get("username"), params.Get("password"))
+	}
+	return url.User(params.Get("username"))
+}
+
+// interfaceAddr return first IPv4 address of network interface
+func interfaceAddr(name string) (*net.TCPAddr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipnet.IP}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no IPv4 address for interface: %s", name)
+}
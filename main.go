@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/AlexxIT/pnproxy/internal/api"
 	"github.com/AlexxIT/pnproxy/internal/app"
+	"github.com/AlexxIT/pnproxy/internal/clients"
 	"github.com/AlexxIT/pnproxy/internal/dns"
 	"github.com/AlexxIT/pnproxy/internal/hosts"
 	"github.com/AlexxIT/pnproxy/internal/http"
 	"github.com/AlexxIT/pnproxy/internal/proxy"
 	"github.com/AlexxIT/pnproxy/internal/tls"
+	"github.com/AlexxIT/pnproxy/internal/upstream"
 )
 
 func main() {
@@ -19,6 +22,8 @@ func main() {
 
 	app.Init()   // before all
 	hosts.Init() // before others
+	clients.Init()
+	upstream.Init()
 
 	api.Init()
 	dns.Init()
@@ -26,7 +31,34 @@ func main() {
 	http.Init()
 	proxy.Init()
 
+	// app doesn't start with config errors, so a module is not silently disabled
+	if errs := app.CheckConfig(); len(errs) > 0 {
+		println("config check: errors", len(errs))
+		os.Exit(1)
+	}
+
+	if app.CheckMode {
+		println("config check: ok")
+		return
+	}
+
+	if err := app.Start(); err != nil {
+		println("start error:", err.Error())
+		os.Exit(1)
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	println("exit with signal:", (<-sigs).String())
+
+	// second signal will force exit
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	if err := app.Stop(ctx); err != nil {
+		os.Exit(1)
+	}
 }